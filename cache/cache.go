@@ -2,16 +2,17 @@ package cache
 
 import (
 	"context"
+	"github.com/fsnotify/fsnotify"
 	"github.com/go-redis/redis/v8"
-	"github.com/patrickmn/go-cache"
 	"liangck.xyz/data-service/sensors-log-acceptor/configer"
-	"liangck.xyz/data-service/sensors-log-acceptor/dao"
 	"liangck.xyz/data-service/sensors-log-acceptor/logger"
+	"strings"
+	"sync"
 	"time"
 )
 
 // -------------------- Cache. Implemented by Redis(https://github.com/go-redis/redis)
-//       and go-cache(https://github.com/patrickmn/go-cache)
+//       and in-process snapshot
 //------------------------
 
 // 设计思路：
@@ -30,6 +31,8 @@ import (
 // A1：1.采用版本号的方式，在redis中每张元数据表维护一个对应的自增版本号，每次修改该表数据时自增（incr），服务内每次查询缓存前先判断redis中的版本号与服务内保存的版本号是否一致，
 //          不一致则查询数据库重新缓存。这样即使服务部署多个实例，也可以保持与数据库数据的一致性。
 //     2.元数据通过管理接口修改时，直接同步更新掉进程内缓存，不过这种方式在元数据管理和数据采集拆分为两个服务、服务部署多个实例时 都不可行。
+//
+// 当前实现：方案二。元数据全部加载为进程内的只读快照（snapshot.go），redis 中维护每张表的版本号（version.go）。
 
 const KeyDelimiter = ":"
 const KeyPrefix = "DBP:META_CACHE:"
@@ -39,6 +42,8 @@ const ValueName = "Value"
 const Field = "Field"
 const EventField = "EventField"
 const FieldEnumValue = "FieldEnumValue"
+const ProfileField = "ProfileField"
+const ItemField = "ItemField"
 const EventKey = KeyPrefix + EventName
 const FieldKey = KeyPrefix + FieldName
 const Version = "version"
@@ -48,13 +53,40 @@ const EventChangeTopic = KeyPrefix + EventName + KeyDelimiter + Change + KeyDeli
 const FieldChangeTopic = KeyPrefix + Field + KeyDelimiter + Change + KeyDelimiter + Topic
 const EventFieldChangeTopic = KeyPrefix + EventField + KeyDelimiter + Change + KeyDelimiter + Topic
 const FieldEnumValueChangeTopic = KeyPrefix + FieldEnumValue + KeyDelimiter + Change + KeyDelimiter + Topic
+const ProfileFieldChangeTopic = KeyPrefix + ProfileField + KeyDelimiter + Change + KeyDelimiter + Topic
+const ItemFieldChangeTopic = KeyPrefix + ItemField + KeyDelimiter + Change + KeyDelimiter + Topic
+
+// 元数据表名，用于缓存刷新指标
+const TableEvents = "dbp_events"
+const TableFields = "dbp_fields"
+const TableEventFields = "dbp_event_fields"
+const TableFieldEnumValues = "dbp_field_enum_values"
+const TableProfileFields = "dbp_profile_fields"
+const TableItemFields = "dbp_item_fields"
 
 // Redis Client
 var redisClient *redis.Client
 var ctx context.Context
 
-// go-cache
-var localCache *cache.Cache
+// 元数据来源
+var provider Provider = dbProvider{}
+
+// 元数据文件监听，文件来源时使用
+var fileWatcher *fsnotify.Watcher
+
+// 所有的 redis 订阅，退出时关闭
+var pubSubs []*redis.PubSub
+var pubSubsMu sync.Mutex
+
+// 元数据变更消息 topic
+var changeTopics = []string{
+	EventChangeTopic,
+	FieldChangeTopic,
+	EventFieldChangeTopic,
+	FieldEnumValueChangeTopic,
+	ProfileFieldChangeTopic,
+	ItemFieldChangeTopic,
+}
 
 // InitRedis init redis client
 func InitRedis(config *configer.Config) {
@@ -66,249 +98,104 @@ func InitRedis(config *configer.Config) {
 	})
 }
 
-// InitLocalCache init local cache instance
-func InitLocalCache(config *configer.Config) {
-	localCache = cache.New(24*time.Hour, 24*time.Hour)
-}
-
 // Init All
+// 首次加载元数据失败时 panic
 func Init(config *configer.Config) {
-	InitRedis(config)
-	InitLocalCache(config)
-
-	events := dao.FindAllEvents()
-	cacheAllEventsLocalWithGiven(events)
-	for _, event := range *events {
-		cacheAllEventFieldsLocal(event.Event)
+	// 文件来源不依赖 redis，配置了 redis 时仍然初始化，供 identity 等模块使用
+	if config.MetadataSource != SourceFile || config.RedisAddr != "" {
+		InitRedis(config)
 	}
-	fields := dao.FindAllFields()
-	cacheAllFieldLocalWithGiven(fields)
-	for _, field := range *fields {
-		cacheAllEnumValuesByField(field.Field)
-	}
-	// listen metadata change and flush local cache
-	go listenEventChange()
-	go listenFieldChange()
-	go listenEventFieldChange()
-	go listenFieldValueChange()
-}
-
-// 缓存指定数据
-
-// 表：dbp_events
-// 缓存方式：Redis、local
-// 数据结构：set
-// key：DBP:META_CACHE:Events
-func cacheAllEvents() {
-	events := dao.FindAllEvents()
-	cacheAllEventsLocalWithGiven(events)
-}
 
-// 本地缓存所有事件
-func cacheAllEventsLocalWithGiven(events *[]dao.DbpEvent) {
-	var eventsSlice = make(map[string]int)
-	for idx, event := range *events {
-		eventsSlice[event.Event] = idx
-	}
-	localCache.Set(EventKey, &eventsSlice, cache.NoExpiration)
-}
-
-// 从本地缓存获取所有定义的事件
-func getAllEventsLocal() *map[string]int {
-	if x, found := localCache.Get(EventKey); found {
-		events := x.(*map[string]int)
-		return events
-	}
-
-	return nil
-}
-
-// EventExists 判断事件是否存在
-func EventExists(event string) bool {
-	eventsLocal := getAllEventsLocal()
-	if eventsLocal != nil {
-		if _, ok := (*eventsLocal)[event]; ok {
-			return true
+	if config.MetadataSource == SourceFile {
+		fileProvider := &FileProvider{Path: config.MetadataFile}
+		provider = fileProvider
+		if err := reload(); err != nil {
+			panic(err)
 		}
+		// reload snapshot when metadata file changed
+		watcher, err := fileProvider.Watch(requestReload)
+		if err != nil {
+			logger.Logger.Error("failed to watch metadata file " + config.MetadataFile + ". caused by: " + err.Error())
+		}
+		fileWatcher = watcher
+		go runReloader(0)
+		return
 	}
 
-	return false
-}
-
-// 监听事件变更并刷新缓存
-func listenEventChange() {
-	logger.Logger.Info("Subscribe EventChangeTopic : " + EventChangeTopic)
-	pubSub := redisClient.Subscribe(ctx, EventChangeTopic)
-	defer pubSub.Close()
-	ch := pubSub.Channel()
-	for msg := range ch {
-		logger.Logger.Info("receive change message: " + msg.Payload)
-		cacheAllEvents()
-	}
-}
-
-// ------ Redis -----
-func cacheAllEventsWithGiven(events *[]dao.DbpEvent) {
-	var eventsSlice = make([]string, 5)
-	for _, event := range *events {
-		eventsSlice = append(eventsSlice, event.Event)
-	}
-	redisClient.SAdd(ctx, EventKey, eventsSlice)
-}
-
-func getAllEvents() []string {
-	stringSliceCmd := redisClient.SMembers(ctx, EventKey)
-	if stringSliceCmd.Err() != nil {
-		logger.Logger.Info(stringSliceCmd.Err().Error())
-	} else {
-		return stringSliceCmd.Val()
-	}
-
-	return nil
-}
-
-// 表：dbp_fields
-// 缓存方式：进程内，go-cache
-// 数据结构：Struct
-// key：DBP:META_CACHE:Field
-func cacheAllFieldsLocal() {
-	fields := dao.FindAllFields()
-	cacheAllFieldLocalWithGiven(fields)
-}
-
-// 本地缓存所有字段元数据
-func cacheAllFieldLocalWithGiven(fields *[]dao.DbpField) {
-	localCache.Set(FieldKey, fields, cache.NoExpiration)
-}
-
-// GetAllFieldLocal 从本地缓存获取所有字段元数据
-func GetAllFieldLocal() *[]dao.DbpField {
-	if x, found := localCache.Get(FieldKey); found && x != nil {
-		fields := x.(*[]dao.DbpField)
-		return fields
-	}
-	return nil
-}
-
-// 监听字段元数据变更
-func listenFieldChange() {
-	logger.Logger.Info("Subscribe FieldChangeTopic : " + FieldChangeTopic)
-	pubSub := redisClient.Subscribe(ctx, FieldChangeTopic)
-	defer pubSub.Close()
-	ch := pubSub.Channel()
-	for msg := range ch {
-		logger.Logger.Info("receive topic " + FieldChangeTopic + "message: " + msg.Payload)
-		cacheAllFieldsLocal()
+	provider = dbProvider{}
+	if err := reload(); err != nil {
+		panic(err)
 	}
-}
-
-// 表：dbp_event_fields
-// 缓存方式：进程内，go-cache
-// 数据结构：Struct
-// key: DBP:META_CACHE:{event}:Fields
-func cacheAllEventFieldsLocal(event string) {
-	fieldByEvent := dao.FindAllEventFieldByEvent(event)
-	cacheAllEventFieldLocalWithGiven(event, fieldByEvent)
-}
-
-// 本地缓存所有事件字段
-func cacheAllEventFieldLocalWithGiven(event string, eventField *[]dao.DbpEventField) {
-	eventFieldKey := getEventFiledCacheKey(event)
-	localCache.Set(eventFieldKey, eventField, cache.NoExpiration)
-}
-
-func getEventFiledCacheKey(event string) string {
-	eventFieldKey := KeyPrefix + event + KeyDelimiter + FieldName
-	return eventFieldKey
-}
-
-// 从本地缓存获取所有事件字段
-func getEventFieldLocalByEvent(event string) *[]dao.DbpEventField {
-	key := getEventFiledCacheKey(event)
-	if x, found := localCache.Get(key); found {
-		fields := x.(*[]dao.DbpEventField)
-		return fields
+	// listen metadata change and reload snapshot
+	go listenMetadataChange()
+	// reload snapshot and reconcile metadata versions in case of missing change message
+	interval := time.Duration(config.CacheReconcileInterval) * time.Second
+	if interval <= 0 {
+		interval = defaultReconcileInterval
 	}
-	return nil
+	go runReloader(interval)
 }
 
-// 监听事件字段元数据变更
-func listenEventFieldChange() {
-	logger.Logger.Info("Subscribe EventFieldChangeTopic : " + EventFieldChangeTopic)
-	pubSub := redisClient.Subscribe(ctx, EventFieldChangeTopic)
-	defer pubSub.Close()
-	ch := pubSub.Channel()
-	for msg := range ch {
-		logger.Logger.Info("receive topic " + string(EventFieldChangeTopic) + "message: " + msg.Payload)
-		cacheAllEventFieldsLocal(msg.Payload)
-	}
+// Versioned 元数据是否有版本号（数据库来源），文件来源没有版本号，也不支持通过管理接口修改
+func Versioned() bool {
+	_, ok := provider.(dbProvider)
+	return ok
 }
 
-// 表：dbp_field_enum_values
-// 缓存方式：redis
-// 数据结构：set
-// key：DBP:META_CACHE:{field}:Value
-func cacheAllEnumValuesByField(field string) {
-	valuesByField := dao.FindAllEnumValuesByField(field)
-	cacheAllEnumValuesLocalWithGiven(field, valuesByField)
+// RedisClient 获取 redis 连接，供其他需要 redis 的模块（如 identity）复用
+func RedisClient() *redis.Client {
+	return redisClient
 }
 
-// 本地缓存所有枚举值
-func cacheAllEnumValuesLocalWithGiven(field string, enumValues *[]dao.DbpFieldEnumValue) {
-	cacheKey := getFieldEnumValueCacheKey(field)
-	var valueMap = make(map[string]int)
-	for idx, enumValue := range *enumValues {
-		valueMap[enumValue.EnumValue] = idx
-	}
-	localCache.Set(cacheKey, &valueMap, cache.NoExpiration)
+// subscribe 订阅指定 channel，并记录订阅用于退出时关闭
+func subscribe(channels ...string) *redis.PubSub {
+	pubSub := redisClient.Subscribe(ctx, channels...)
+	pubSubsMu.Lock()
+	pubSubs = append(pubSubs, pubSub)
+	pubSubsMu.Unlock()
+	return pubSub
 }
 
-// GetAllEnumValuesLocalByField 从本地缓存获取所有枚举值
-func GetAllEnumValuesLocalByField(field string) *map[string]int {
-	cacheKey := getFieldEnumValueCacheKey(field)
-	if x, found := localCache.Get(cacheKey); found {
-		fieldValuesMap := x.(*map[string]int)
-		return fieldValuesMap
+// Close 停止加载任务和文件监听，关闭所有 redis 订阅（监听 goroutine 随之退出）和 redis 连接
+func Close() error {
+	stopReloading()
+	if fileWatcher != nil {
+		_ = fileWatcher.Close()
 	}
-
-	return nil
-}
-
-func getFieldEnumValueCacheKey(field string) string {
-	cacheKey := KeyPrefix + field + KeyDelimiter + ValueName
-	return cacheKey
-}
-
-// FieldEnumValueExists 判断枚举值是否存在
-func FieldEnumValueExists(field string, value string) bool {
-	enumValuesMap := GetAllEnumValuesLocalByField(field)
-	if enumValuesMap != nil {
-		if _, ok := (*enumValuesMap)[value]; ok {
-			return true
+	pubSubsMu.Lock()
+	defer pubSubsMu.Unlock()
+	for _, pubSub := range pubSubs {
+		if err := pubSub.Close(); err != nil {
+			logger.Logger.Error("failed to close redis subscription: " + err.Error())
 		}
 	}
-
-	return false
+	pubSubs = nil
+	if redisClient == nil {
+		return nil
+	}
+	return redisClient.Close()
 }
 
-// 监听事件字段元数据变更
-func listenFieldValueChange() {
-	logger.Logger.Info("Subscribe FieldEnumValueChangeTopic : " + FieldEnumValueChangeTopic)
-	pubSub := redisClient.Subscribe(ctx, FieldEnumValueChangeTopic)
+// 监听元数据变更，任意元数据表变更都重新构建整个快照
+func listenMetadataChange() {
+	logger.Logger.Info("Subscribe metadata change topics : " + strings.Join(changeTopics, ", "))
+	pubSub := subscribe(changeTopics...)
 	defer pubSub.Close()
 	ch := pubSub.Channel()
 	for msg := range ch {
-		logger.Logger.Info("receive topic " + FieldEnumValueChangeTopic + "message: " + msg.Payload)
-		cacheAllEnumValuesByField(msg.Payload)
+		logger.Logger.Info("receive topic " + msg.Channel + " message: " + msg.Payload)
+		requestReload()
 	}
 }
 
 // SendFieldChangeMessage publish change message to channel
 func SendFieldChangeMessage() {
+	incrVersion(TableFields)
 	redisClient.Publish(ctx, FieldChangeTopic, "field change")
 }
 
 func SendEventChangeMessage() {
+	incrVersion(TableEvents)
 	err := redisClient.Publish(ctx, EventChangeTopic, "event change").Err()
 	if err != nil {
 		logger.Logger.Error("failed to publish message to " + EventChangeTopic + " caused: " + err.Error())
@@ -316,19 +203,21 @@ func SendEventChangeMessage() {
 }
 
 func SendEventFieldChangeMessage(event string) {
+	incrVersion(TableEventFields)
 	redisClient.Publish(ctx, EventFieldChangeTopic, event)
 }
 
 func SendFieldValuesChangeMessage(field string) {
+	incrVersion(TableFieldEnumValues)
 	redisClient.Publish(ctx, FieldEnumValueChangeTopic, field)
 }
 
-// redis -----------
-func cacheAllEnumValuesWithGiven(field string, enumValues *[]dao.DbpFieldEnumValue) {
-	cacheKey := getFieldEnumValueCacheKey(field)
-	var valueSlice = make([]string, 5)
-	for _, enumValue := range *enumValues {
-		valueSlice = append(valueSlice, enumValue.EnumValue)
-	}
-	redisClient.SAdd(ctx, cacheKey, valueSlice)
+func SendProfileFieldChangeMessage() {
+	incrVersion(TableProfileFields)
+	redisClient.Publish(ctx, ProfileFieldChangeTopic, "profile field change")
+}
+
+func SendItemFieldChangeMessage() {
+	incrVersion(TableItemFields)
+	redisClient.Publish(ctx, ItemFieldChangeTopic, "item field change")
 }
@@ -1,6 +1,9 @@
 package cache
 
 import (
+	"go.uber.org/zap"
+	"liangck.xyz/data-service/sensors-log-acceptor/dao"
+	"liangck.xyz/data-service/sensors-log-acceptor/logger"
 	"testing"
 )
 
@@ -47,3 +50,81 @@ func TestCache(t *testing.T) {
 	//	print(i)
 	//}
 }
+
+func TestSnapshotFieldsByEvent(t *testing.T) {
+	logger.Logger = zap.NewNop()
+	snapshot := NewMetadataSnapshot(&Metadata{
+		Events: []dao.DbpEvent{{Event: "pay_order"}, {Event: "page_view"}, {Event: "app_start"}},
+		Fields: []dao.DbpField{
+			{Field: "distinct_id", Type: "string", Nullable: false},
+			{Field: "order_id", Type: "string", Nullable: true},
+			{Field: "page_id", Type: "string", Nullable: true},
+		},
+		EventFields: []dao.DbpEventField{
+			{Event: "pay_order", Field: "distinct_id", Nullable: false},
+			{Event: "pay_order", Field: "order_id", Nullable: false},
+			{Event: "pay_order", Field: "undefined_field", Nullable: false},
+			{Event: "app_start", Field: "undefined_field", Nullable: false},
+		},
+	}, map[string]int64{TableFields: 2})
+
+	payOrderFields := snapshot.FieldsByEvent("pay_order")
+	if len(payOrderFields) != 2 {
+		t.Fatalf("pay_order should validate 2 fields, got %d", len(payOrderFields))
+	}
+	if payOrderFields[1].Field != "order_id" || payOrderFields[1].Nullable {
+		t.Errorf("order_id should be required for pay_order, got %v", payOrderFields[1])
+	}
+
+	// 未配置事件字段的事件使用全局字段列表
+	pageViewFields := snapshot.FieldsByEvent("page_view")
+	if len(pageViewFields) != 3 || !pageViewFields[1].Nullable {
+		t.Errorf("page_view should fall back to all fields, got %v", pageViewFields)
+	}
+
+	// 配置的事件字段都未定义时不校验任何字段，不回退为全局字段列表
+	if appStartFields := snapshot.FieldsByEvent("app_start"); len(appStartFields) != 0 {
+		t.Errorf("app_start should validate no fields, got %v", appStartFields)
+	}
+
+	// 事件字段覆盖 Nullable 不能影响全局字段定义
+	if !snapshot.Fields()[1].Nullable {
+		t.Errorf("global order_id definition should stay nullable")
+	}
+
+	if !snapshot.EventExists("pay_order") || snapshot.EventExists("undefined_event") {
+		t.Errorf("only defined events should exist")
+	}
+	if snapshot.Versions[TableFields] != 2 || snapshot.Versions[TableEvents] != 0 {
+		t.Errorf("unexpected versions %v", snapshot.Versions)
+	}
+}
+
+func TestSnapshotBeforeLoad(t *testing.T) {
+	snapshot := Snapshot()
+	if snapshot == nil || snapshot.EventExists("page_view") || len(snapshot.FieldsByEvent("page_view")) != 0 {
+		t.Errorf("snapshot before load should be empty")
+	}
+	if _, ok := snapshot.ItemFields("book"); ok {
+		t.Errorf("item type should be undefined before load")
+	}
+}
+
+func TestSnapshotFieldTree(t *testing.T) {
+	snapshot := NewMetadataSnapshot(&Metadata{
+		Fields: []dao.DbpField{
+			{Field: "address", JsonPath: "properties.address", Type: "object"},
+			{Field: "city", JsonPath: "city", Type: "string", Parent: "address"},
+			{Field: "geo", JsonPath: "geo", Type: "object", Parent: "address"},
+			{Field: "lat", JsonPath: "lat", Type: "float", Parent: "geo"},
+		},
+	}, nil)
+
+	fields := snapshot.Fields()
+	if len(fields) != 1 || fields[0].Field != "address" {
+		t.Fatalf("only address should be top level field, got %v", fields)
+	}
+	if children := fields[0].Children; len(children) != 2 || len(children[1].Children) != 1 || children[1].Children[0].Field != "lat" {
+		t.Fatalf("address should have children city and geo(lat), got %v", children)
+	}
+}
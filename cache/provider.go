@@ -0,0 +1,283 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/fsnotify/fsnotify"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"liangck.xyz/data-service/sensors-log-acceptor/constraint"
+	"liangck.xyz/data-service/sensors-log-acceptor/dao"
+	"liangck.xyz/data-service/sensors-log-acceptor/logger"
+	"liangck.xyz/data-service/sensors-log-acceptor/model"
+	"path/filepath"
+	"strings"
+)
+
+// 元数据来源
+// db：从数据库（dbp_* 表）加载，通过 redis 变更消息和版本号刷新（默认）
+// file：从 YAML/JSON 文件加载，文件修改后自动重新加载，不依赖数据库和 redis，用于边缘部署和本地开发
+
+const SourceDB = "db"
+const SourceFile = "file"
+
+// Provider 元数据来源
+type Provider interface {
+	// Load 加载所有元数据，加载失败返回 error（保留之前的快照）
+	Load() (*Metadata, error)
+}
+
+// tableProvider 支持按表加载元数据的来源，对账时只重新加载版本号变化的表
+type tableProvider interface {
+	// LoadTables 重新加载指定的表，其他表沿用 previous 中的数据
+	LoadTables(previous *Metadata, changed []string) (*Metadata, error)
+}
+
+// dbProvider 从数据库加载元数据
+type dbProvider struct{}
+
+func (dbProvider) Load() (*Metadata, error) {
+	return LoadMetadata()
+}
+
+func (dbProvider) LoadTables(previous *Metadata, changed []string) (*Metadata, error) {
+	return LoadTables(previous, changed)
+}
+
+// FileProvider 从文件加载元数据，根据扩展名（.yaml、.yml、.json）解析
+type FileProvider struct {
+	Path string
+}
+
+// MetadataFormatVersion 当前元数据文件（schema bundle）格式版本
+const MetadataFormatVersion = 1
+
+// MetadataFile 元数据文件格式，也是环境间迁移元数据的 schema bundle 格式
+type MetadataFile struct {
+	// schema bundle 导出信息，手写元数据文件时可省略
+	FormatVersion int    `yaml:"formatVersion,omitempty" json:"formatVersion,omitempty"`
+	Env           string `yaml:"env,omitempty" json:"env,omitempty"`
+	ExportedAt    string `yaml:"exportedAt,omitempty" json:"exportedAt,omitempty"`
+
+	Events        []FileEvent      `yaml:"events" json:"events"`
+	Fields        []FileField      `yaml:"fields" json:"fields"`
+	EventFields   []FileEventField `yaml:"eventFields" json:"eventFields"`
+	EnumValues    []FileEnumValue  `yaml:"enumValues" json:"enumValues"`
+	ProfileFields []FileField      `yaml:"profileFields" json:"profileFields"`
+	ItemFields    []FileItemField  `yaml:"itemFields" json:"itemFields"`
+}
+
+type FileEvent struct {
+	Event       string `yaml:"event" json:"event"`
+	Description string `yaml:"description" json:"description"`
+	Policy      string `yaml:"policy,omitempty" json:"policy,omitempty"`
+	// 未定义属性的处理方式
+	UnknownProperties string `yaml:"unknownProperties,omitempty" json:"unknownProperties,omitempty"`
+}
+
+type FileField struct {
+	Field    string `yaml:"field" json:"field"`
+	JsonPath string `yaml:"jsonPath" json:"jsonPath"`
+	Type     string `yaml:"type" json:"type"`
+	Length   int    `yaml:"length" json:"length"`
+	Name     string `yaml:"name" json:"name"`
+	Nullable bool   `yaml:"nullable" json:"nullable"`
+	// list 类型元素类型、最大元素个数，object 类型子字段所属的字段
+	ElementType string `yaml:"elementType,omitempty" json:"elementType,omitempty"`
+	MaxElements int    `yaml:"maxElements,omitempty" json:"maxElements,omitempty"`
+	Parent      string `yaml:"parent,omitempty" json:"parent,omitempty"`
+	// 字段值约束、校验失败处理策略、类型转换，只支持字段定义（fields），用户属性、物品字段不支持
+	Constraints *constraint.Constraints `yaml:"constraints,omitempty" json:"constraints,omitempty"`
+	Policy      string                  `yaml:"policy,omitempty" json:"policy,omitempty"`
+	Coerce      bool                    `yaml:"coerce,omitempty" json:"coerce,omitempty"`
+}
+
+type FileEventField struct {
+	Event       string                  `yaml:"event" json:"event"`
+	Field       string                  `yaml:"field" json:"field"`
+	Nullable    bool                    `yaml:"nullable" json:"nullable"`
+	Constraints *constraint.Constraints `yaml:"constraints,omitempty" json:"constraints,omitempty"`
+	Policy      string                  `yaml:"policy,omitempty" json:"policy,omitempty"`
+}
+
+type FileEnumValue struct {
+	Field     string `yaml:"field" json:"field"`
+	EnumValue string `yaml:"enumValue" json:"enumValue"`
+	ValueName string `yaml:"valueName" json:"valueName"`
+}
+
+type FileItemField struct {
+	ItemType  string `yaml:"itemType" json:"itemType"`
+	FileField `yaml:",inline"`
+}
+
+// Load 读取并解析元数据文件
+func (p *FileProvider) Load() (*Metadata, error) {
+	content, err := ioutil.ReadFile(p.Path)
+	if err != nil {
+		return nil, err
+	}
+	var file MetadataFile
+	if strings.EqualFold(filepath.Ext(p.Path), ".json") {
+		// 与 yaml 一致，不认识的 key（拼写错误）报错
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&file)
+	} else {
+		err = yaml.UnmarshalStrict(content, &file)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := file.validPolicies(); err != nil {
+		return nil, err
+	}
+	return file.ToMetadata(), nil
+}
+
+// validPolicies 校验事件、字段、事件字段配置的处理策略和未定义属性处理方式，为空或支持的值
+// 文件由人工编辑，拼写错误时加载失败（保留之前的快照），而不是静默使用上一级的策略
+func (f *MetadataFile) validPolicies() error {
+	for _, event := range f.Events {
+		if event.Policy != "" && !model.Policies[event.Policy] {
+			return errors.New("invalid policy [" + event.Policy + "] of event [" + event.Event + "]")
+		}
+		if event.UnknownProperties != "" && !model.UnknownPropertiesModes[event.UnknownProperties] {
+			return errors.New("invalid unknownProperties [" + event.UnknownProperties + "] of event [" + event.Event + "]")
+		}
+	}
+	for _, field := range f.Fields {
+		if field.Policy != "" && !model.Policies[field.Policy] {
+			return errors.New("invalid policy [" + field.Policy + "] of field [" + field.Field + "]")
+		}
+	}
+	for _, eventField := range f.EventFields {
+		if eventField.Policy != "" && !model.Policies[eventField.Policy] {
+			return errors.New("invalid policy [" + eventField.Policy + "] of event field [" + eventField.Event + "." + eventField.Field + "]")
+		}
+	}
+	return nil
+}
+
+// ToMetadata 转换为元数据
+func (f *MetadataFile) ToMetadata() *Metadata {
+	metadata := &Metadata{}
+	for _, event := range f.Events {
+		metadata.Events = append(metadata.Events, dao.DbpEvent{Event: event.Event, Description: event.Description, Policy: event.Policy,
+			UnknownProperties: event.UnknownProperties})
+	}
+	for _, field := range f.Fields {
+		metadata.Fields = append(metadata.Fields, field.toDbpField())
+	}
+	for _, eventField := range f.EventFields {
+		metadata.EventFields = append(metadata.EventFields, dao.DbpEventField{Event: eventField.Event, Field: eventField.Field, Nullable: eventField.Nullable,
+			Constraints: eventField.Constraints.String(), Policy: eventField.Policy})
+	}
+	for _, enumValue := range f.EnumValues {
+		metadata.EnumValues = append(metadata.EnumValues, dao.DbpFieldEnumValue{Field: enumValue.Field, EnumValue: enumValue.EnumValue, ValueName: enumValue.ValueName})
+	}
+	for _, field := range f.ProfileFields {
+		metadata.ProfileFields = append(metadata.ProfileFields, dao.DbpProfileField{Field: field.Field, JsonPath: field.JsonPath, Type: field.Type, Length: field.Length, Name: field.Name, Nullable: field.Nullable,
+			ElementType: field.ElementType, MaxElements: field.MaxElements, Parent: field.Parent})
+	}
+	for _, field := range f.ItemFields {
+		metadata.ItemFields = append(metadata.ItemFields, dao.DbpItemField{ItemType: field.ItemType, Field: field.Field, JsonPath: field.JsonPath, Type: field.Type, Length: field.Length, Name: field.Name, Nullable: field.Nullable,
+			ElementType: field.ElementType, MaxElements: field.MaxElements, Parent: field.Parent})
+	}
+	return metadata
+}
+
+// NewMetadataFile 元数据转换为文件格式
+func NewMetadataFile(metadata *Metadata) *MetadataFile {
+	file := &MetadataFile{}
+	for _, event := range metadata.Events {
+		file.Events = append(file.Events, FileEvent{Event: event.Event, Description: event.Description, Policy: event.Policy,
+			UnknownProperties: event.UnknownProperties})
+	}
+	for _, field := range metadata.Fields {
+		file.Fields = append(file.Fields, newFileField(field))
+	}
+	for _, eventField := range metadata.EventFields {
+		file.EventFields = append(file.EventFields, FileEventField{Event: eventField.Event, Field: eventField.Field, Nullable: eventField.Nullable,
+			Constraints: parseConstraints(eventField.Constraints), Policy: eventField.Policy})
+	}
+	for _, enumValue := range metadata.EnumValues {
+		file.EnumValues = append(file.EnumValues, FileEnumValue{Field: enumValue.Field, EnumValue: enumValue.EnumValue, ValueName: enumValue.ValueName})
+	}
+	for _, profileField := range metadata.ProfileFields {
+		file.ProfileFields = append(file.ProfileFields, newFileField(profileField.ToDbpField()))
+	}
+	for _, itemField := range metadata.ItemFields {
+		file.ItemFields = append(file.ItemFields, FileItemField{ItemType: itemField.ItemType, FileField: newFileField(itemField.ToDbpField())})
+	}
+	return file
+}
+
+func newFileField(field dao.DbpField) FileField {
+	return FileField{Field: field.Field, JsonPath: field.JsonPath, Type: field.Type, Length: field.Length, Name: field.Name, Nullable: field.Nullable,
+		ElementType: field.ElementType, MaxElements: field.MaxElements, Parent: field.Parent, Constraints: parseConstraints(field.Constraints),
+		Policy: field.Policy, Coerce: field.Coerce}
+}
+
+// parseConstraints 解析数据库中的约束，无法解析的约束在构建快照时也会被忽略
+func parseConstraints(raw string) *constraint.Constraints {
+	constraints, err := constraint.Parse(raw)
+	if err != nil {
+		logger.Logger.Warn("invalid constraints " + raw + " ignored. caused by: " + err.Error())
+		return nil
+	}
+	return constraints
+}
+
+func (f FileField) toDbpField() dao.DbpField {
+	return dao.DbpField{Field: f.Field, JsonPath: f.JsonPath, Type: f.Type, Length: f.Length, Name: f.Name, Nullable: f.Nullable,
+		ElementType: f.ElementType, MaxElements: f.MaxElements, Parent: f.Parent, Constraints: f.Constraints.String(),
+		Policy: f.Policy, Coerce: f.Coerce}
+}
+
+// Watch 监听元数据文件修改，修改后调用 onChange
+// 监听文件所在目录而不是文件本身，编辑器保存时通常先写临时文件再重命名覆盖，直接监听文件会丢失后续修改。
+// 文件为符号链接时（如 Kubernetes 挂载的 ConfigMap：metadata.yaml -> ..data/metadata.yaml，更新时替换 ..data 链接），
+// 文件本身没有事件，所以目录中有任何事件时重新解析链接，指向的文件变化时同样视为修改
+func (p *FileProvider) Watch(onChange func()) (*fsnotify.Watcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err := watcher.Add(filepath.Dir(p.Path)); err != nil {
+		_ = watcher.Close()
+		return nil, err
+	}
+
+	path := filepath.Clean(p.Path)
+	realPath, _ := filepath.EvalSymlinks(path)
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) == path && event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) != 0 {
+					realPath, _ = filepath.EvalSymlinks(path)
+					logger.Logger.Info("metadata file " + event.Name + " changed: " + event.Op.String())
+					onChange()
+					continue
+				}
+				// 链接指向的文件变化（解析失败时为链接替换过程中，等待下一个事件）
+				if currentPath, err := filepath.EvalSymlinks(path); err == nil && currentPath != realPath {
+					realPath = currentPath
+					logger.Logger.Info("metadata file " + path + " now links to " + currentPath)
+					onChange()
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logger.Logger.Error("failed to watch metadata file. caused by: " + err.Error())
+			}
+		}
+	}()
+	return watcher, nil
+}
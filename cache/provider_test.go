@@ -0,0 +1,112 @@
+package cache
+
+import (
+	"go.uber.org/zap"
+	"io/ioutil"
+	"liangck.xyz/data-service/sensors-log-acceptor/dao"
+	"liangck.xyz/data-service/sensors-log-acceptor/logger"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileProviderLoad(t *testing.T) {
+	logger.Logger = zap.NewNop()
+	metadata, err := (&FileProvider{Path: "../configs/metadata.yaml"}).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot := NewMetadataSnapshot(metadata, nil)
+	if !snapshot.EventExists("page_view") || !snapshot.FieldEnumValueExists("platform", "web") {
+		t.Errorf("page_view and platform web should be defined")
+	}
+	if fields := snapshot.FieldsByEvent("$SignUp"); len(fields) != 2 || fields[0].Nullable {
+		t.Errorf("$SignUp should validate distinct_id and time, got %v", fields)
+	}
+
+	path := filepath.Join(t.TempDir(), "metadata.json")
+	_ = ioutil.WriteFile(path, []byte(`{"events":[{"event":"pay_order"}],"itemFields":[{"itemType":"book","field":"price","jsonPath":"properties.price","type":"float","length":32}]}`), 0644)
+	metadata, err = (&FileProvider{Path: path}).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metadata.Events) != 1 || len(metadata.ItemFields) != 1 || metadata.ItemFields[0].Field != "price" {
+		t.Errorf("unexpected metadata from json %+v", metadata)
+	}
+
+	// 未知字段（拼写错误）报错
+	yamlPath := filepath.Join(t.TempDir(), "metadata.yaml")
+	_ = ioutil.WriteFile(yamlPath, []byte("events:\n  - evnet: pay_order\n"), 0644)
+	if _, err := (&FileProvider{Path: yamlPath}).Load(); err == nil {
+		t.Errorf("unknown yaml key should fail")
+	}
+
+	jsonPath := filepath.Join(t.TempDir(), "metadata.json")
+	_ = ioutil.WriteFile(jsonPath, []byte(`{"events":[{"evnet":"pay_order"}]}`), 0644)
+	if _, err := (&FileProvider{Path: jsonPath}).Load(); err == nil {
+		t.Errorf("unknown json key should fail")
+	}
+
+	// 不支持的处理策略（拼写错误）报错
+	_ = ioutil.WriteFile(yamlPath, []byte("events:\n  - event: pay_order\n    policy: drop-field\n"), 0644)
+	if _, err := (&FileProvider{Path: yamlPath}).Load(); err == nil {
+		t.Errorf("invalid policy should fail")
+	}
+
+	// 数据库中不支持的处理策略在快照中忽略
+	snapshot = NewMetadataSnapshot(&Metadata{Events: []dao.DbpEvent{{Event: "pay_order", Policy: "drop-field", UnknownProperties: "strict"}}}, nil)
+	if snapshot.EventPolicy("pay_order") != "" || snapshot.EventUnknownProperties("pay_order") != "strict" {
+		t.Errorf("invalid policy should be ignored in snapshot")
+	}
+}
+
+func TestFileProviderWatch(t *testing.T) {
+	logger.Logger = zap.NewNop()
+	path := filepath.Join(t.TempDir(), "metadata.yaml")
+	_ = ioutil.WriteFile(path, []byte("events: []\n"), 0644)
+
+	changed := make(chan struct{}, 10)
+	watcher, err := (&FileProvider{Path: path}).Watch(func() { changed <- struct{}{} })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Close()
+
+	// 同目录其他文件的修改不触发
+	_ = ioutil.WriteFile(filepath.Join(filepath.Dir(path), "other.yaml"), []byte("x"), 0644)
+	_ = ioutil.WriteFile(path, []byte("events:\n  - event: page_view\n"), 0644)
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("change of metadata file should be notified")
+	}
+}
+
+func TestFileProviderWatchSymlinkSwap(t *testing.T) {
+	logger.Logger = zap.NewNop()
+	// 模拟 Kubernetes ConfigMap 挂载：metadata.yaml -> ..data/metadata.yaml，..data -> ..v1，更新时替换 ..data
+	dir := t.TempDir()
+	for _, version := range []string{"..v1", "..v2"} {
+		_ = os.Mkdir(filepath.Join(dir, version), 0755)
+		_ = ioutil.WriteFile(filepath.Join(dir, version, "metadata.yaml"), []byte("events: []\n"), 0644)
+	}
+	_ = os.Symlink("..v1", filepath.Join(dir, "..data"))
+	path := filepath.Join(dir, "metadata.yaml")
+	_ = os.Symlink(filepath.Join("..data", "metadata.yaml"), path)
+
+	changed := make(chan struct{}, 10)
+	watcher, err := (&FileProvider{Path: path}).Watch(func() { changed <- struct{}{} })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Close()
+
+	_ = os.Symlink("..v2", filepath.Join(dir, "..data_tmp"))
+	_ = os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data"))
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("swap of ..data symlink should be notified")
+	}
+}
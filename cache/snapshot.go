@@ -0,0 +1,328 @@
+package cache
+
+import (
+	"liangck.xyz/data-service/sensors-log-acceptor/constraint"
+	"liangck.xyz/data-service/sensors-log-acceptor/dao"
+	"liangck.xyz/data-service/sensors-log-acceptor/logger"
+	"liangck.xyz/data-service/sensors-log-acceptor/model"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// 元数据快照
+// 快照从数据库一次性完整构建，构建后只读，通过 atomic.Value 整体替换。
+// 每条数据校验前获取一次快照，整个校验过程使用同一版本的元数据，不会出现新的字段列表与旧的枚举值混用；
+// 重新加载失败（数据库不可用）时保留之前的快照，不会缓存空数据。
+
+var currentSnapshot atomic.Value
+
+// 未加载元数据前使用的空快照
+var emptySnapshot = NewMetadataSnapshot(&Metadata{}, nil)
+
+// Metadata 所有元数据表的数据
+type Metadata struct {
+	Events        []dao.DbpEvent
+	Fields        []dao.DbpField
+	EventFields   []dao.DbpEventField
+	EnumValues    []dao.DbpFieldEnumValue
+	ProfileFields []dao.DbpProfileField
+	ItemFields    []dao.DbpItemField
+}
+
+// MetadataSnapshot 元数据快照，只读
+type MetadataSnapshot struct {
+	Versions map[string]int64 // 构建快照前读取的各元数据表版本号
+	LoadedAt time.Time
+
+	events        map[string]bool
+	eventPolicies map[string]string
+	// 事件配置的未定义属性处理方式
+	eventUnknownProperties map[string]string
+	fields                 []dao.DbpField
+	eventFields            map[string][]dao.DbpField // 配置了事件字段的事件需要校验的字段
+	enumValues             map[string]map[string]bool
+	profileFields          []dao.DbpField
+	itemFields             map[string][]dao.DbpField
+
+	// 构建快照的元数据，对账时只重新加载版本号变化的表，其他表沿用
+	metadata *Metadata
+}
+
+// LoadMetadata 从数据库加载所有元数据表，任意一张表加载失败返回 error
+func LoadMetadata() (*Metadata, error) {
+	events, err := dao.FindAllEvents()
+	if err != nil {
+		return nil, err
+	}
+	fields, err := dao.FindAllFields()
+	if err != nil {
+		return nil, err
+	}
+	eventFields, err := dao.FindAllEventFields()
+	if err != nil {
+		return nil, err
+	}
+	enumValues, err := dao.FindAllEnumValues()
+	if err != nil {
+		return nil, err
+	}
+	profileFields, err := dao.FindAllProfileFields()
+	if err != nil {
+		return nil, err
+	}
+	itemFields, err := dao.FindAllItemFields()
+	if err != nil {
+		return nil, err
+	}
+	return &Metadata{
+		Events:        *events,
+		Fields:        *fields,
+		EventFields:   *eventFields,
+		EnumValues:    *enumValues,
+		ProfileFields: *profileFields,
+		ItemFields:    *itemFields,
+	}, nil
+}
+
+// LoadTables 从数据库重新加载指定的元数据表，其他表沿用 previous 中的数据，任意一张表加载失败返回 error
+func LoadTables(previous *Metadata, changed []string) (*Metadata, error) {
+	metadata := *previous
+	for _, table := range changed {
+		switch table {
+		case TableEvents:
+			events, err := dao.FindAllEvents()
+			if err != nil {
+				return nil, err
+			}
+			metadata.Events = *events
+		case TableFields:
+			fields, err := dao.FindAllFields()
+			if err != nil {
+				return nil, err
+			}
+			metadata.Fields = *fields
+		case TableEventFields:
+			eventFields, err := dao.FindAllEventFields()
+			if err != nil {
+				return nil, err
+			}
+			metadata.EventFields = *eventFields
+		case TableFieldEnumValues:
+			enumValues, err := dao.FindAllEnumValues()
+			if err != nil {
+				return nil, err
+			}
+			metadata.EnumValues = *enumValues
+		case TableProfileFields:
+			profileFields, err := dao.FindAllProfileFields()
+			if err != nil {
+				return nil, err
+			}
+			metadata.ProfileFields = *profileFields
+		case TableItemFields:
+			itemFields, err := dao.FindAllItemFields()
+			if err != nil {
+				return nil, err
+			}
+			metadata.ItemFields = *itemFields
+		}
+	}
+	return &metadata, nil
+}
+
+// NewMetadataSnapshot 构建元数据快照
+// 事件在 dbp_event_fields 中有配置时，只校验配置的字段，并以事件字段配置的 Nullable 覆盖字段定义；
+// 事件没有配置事件字段时，使用全局字段列表
+func NewMetadataSnapshot(metadata *Metadata, versions map[string]int64) *MetadataSnapshot {
+	snapshot := &MetadataSnapshot{
+		Versions:               make(map[string]int64, len(tables)),
+		LoadedAt:               time.Now(),
+		events:                 make(map[string]bool, len(metadata.Events)),
+		eventPolicies:          make(map[string]string),
+		eventUnknownProperties: make(map[string]string),
+		fields:                 buildFieldTree(metadata.Fields),
+		eventFields:            make(map[string][]dao.DbpField),
+		enumValues:             make(map[string]map[string]bool),
+		itemFields:             make(map[string][]dao.DbpField),
+		metadata:               metadata,
+	}
+	for _, table := range tables {
+		snapshot.Versions[table] = versions[table]
+	}
+	for _, event := range metadata.Events {
+		snapshot.events[event.Event] = true
+		if policy := validPolicy("event ["+event.Event+"]", event.Policy); policy != "" {
+			snapshot.eventPolicies[event.Event] = policy
+		}
+		if mode := validUnknownProperties(event.Event, event.UnknownProperties); mode != "" {
+			snapshot.eventUnknownProperties[event.Event] = mode
+		}
+	}
+
+	fieldMap := make(map[string]dao.DbpField, len(snapshot.fields))
+	for _, field := range snapshot.fields {
+		fieldMap[field.Field] = field
+	}
+	for _, eventField := range metadata.EventFields {
+		// 事件配置了字段时只校验配置的字段，所有字段都被跳过时也不能回退为校验所有字段
+		if _, ok := snapshot.eventFields[eventField.Event]; !ok {
+			snapshot.eventFields[eventField.Event] = nil
+		}
+		field, ok := fieldMap[eventField.Field]
+		if !ok {
+			logger.Logger.Warn("event [" + eventField.Event + "] field [" + eventField.Field + "] is not defined in dbp_fields, skipped")
+			continue
+		}
+		field.Nullable = eventField.Nullable
+		if policy := validPolicy("event field ["+eventField.Event+"."+eventField.Field+"]", eventField.Policy); policy != "" {
+			field.Policy = policy
+		}
+		if eventField.Constraints != "" {
+			field.Rules = compileRules(eventField.Event+"."+eventField.Field, eventField.Constraints)
+		}
+		snapshot.eventFields[eventField.Event] = append(snapshot.eventFields[eventField.Event], field)
+	}
+
+	for _, enumValue := range metadata.EnumValues {
+		if snapshot.enumValues[enumValue.Field] == nil {
+			snapshot.enumValues[enumValue.Field] = make(map[string]bool)
+		}
+		snapshot.enumValues[enumValue.Field][enumValue.EnumValue] = true
+	}
+
+	profileFields := make([]dao.DbpField, 0, len(metadata.ProfileFields))
+	for _, profileField := range metadata.ProfileFields {
+		profileFields = append(profileFields, profileField.ToDbpField())
+	}
+	snapshot.profileFields = buildFieldTree(profileFields)
+	for _, itemField := range metadata.ItemFields {
+		snapshot.itemFields[itemField.ItemType] = append(snapshot.itemFields[itemField.ItemType], itemField.ToDbpField())
+	}
+	for itemType, itemFields := range snapshot.itemFields {
+		snapshot.itemFields[itemType] = buildFieldTree(itemFields)
+	}
+	return snapshot
+}
+
+// validPolicy 配置的处理策略为空或支持的策略时原样返回；不支持的策略（如拼写错误）输出警告并忽略，使用上一级的策略
+func validPolicy(name string, policy string) string {
+	if policy == "" || model.Policies[policy] {
+		return policy
+	}
+	logger.Logger.Warn("invalid policy [" + policy + "] of " + name + ", ignored")
+	return ""
+}
+
+// validUnknownProperties 事件配置的未定义属性处理方式，不支持的处理方式输出警告并忽略，使用全局配置
+func validUnknownProperties(event string, mode string) string {
+	if mode == "" || model.UnknownPropertiesModes[mode] {
+		return mode
+	}
+	logger.Logger.Warn("invalid unknown properties mode [" + mode + "] of event [" + event + "], ignored")
+	return ""
+}
+
+// buildFieldTree 把子字段（Parent 不为空）挂到所属 object 字段的 Children 下，返回顶层字段
+// 所属字段不存在的子字段不会被校验
+// 同时编译字段值约束
+func buildFieldTree(fields []dao.DbpField) []dao.DbpField {
+	roots := make([]dao.DbpField, 0, len(fields))
+	children := make(map[string][]dao.DbpField)
+	for _, field := range fields {
+		field.Rules = compileRules(field.Field, field.Constraints)
+		field.Policy = validPolicy("field ["+field.Field+"]", field.Policy)
+		if field.Parent == "" {
+			roots = append(roots, field)
+		} else {
+			children[field.Parent] = append(children[field.Parent], field)
+		}
+	}
+	return attachChildren(roots, children, make(map[string]bool))
+}
+
+// compileRules 编译字段值约束，约束配置错误时忽略约束
+func compileRules(field string, constraints string) *constraint.Rules {
+	rules, err := constraint.Compile(constraints)
+	if err != nil {
+		logger.Logger.Warn("invalid constraints of field [" + field + "], ignored. caused by: " + err.Error())
+		return nil
+	}
+	return rules
+}
+
+// attachChildren 递归填充子字段，ancestors 为当前路径上的字段，防止配置错误（子字段与祖先同名）导致死循环
+func attachChildren(fields []dao.DbpField, children map[string][]dao.DbpField, ancestors map[string]bool) []dao.DbpField {
+	for idx := range fields {
+		name := fields[idx].Field
+		if ancestors[name] || len(children[name]) == 0 {
+			continue
+		}
+		ancestors[name] = true
+		fields[idx].Children = attachChildren(append([]dao.DbpField(nil), children[name]...), children, ancestors)
+		delete(ancestors, name)
+	}
+	return fields
+}
+
+// Snapshot 获取当前的元数据快照
+func Snapshot() *MetadataSnapshot {
+	if snapshot, ok := currentSnapshot.Load().(*MetadataSnapshot); ok {
+		return snapshot
+	}
+	return emptySnapshot
+}
+
+// EventExists 判断事件是否存在
+func (s *MetadataSnapshot) EventExists(event string) bool {
+	return s.events[event]
+}
+
+// Events 所有已定义的事件名
+func (s *MetadataSnapshot) Events() []string {
+	events := make([]string, 0, len(s.events))
+	for event := range s.events {
+		events = append(events, event)
+	}
+	sort.Strings(events)
+	return events
+}
+
+// EventPolicy 事件配置的字段校验失败处理策略，未配置时为空字符串
+func (s *MetadataSnapshot) EventPolicy(event string) string {
+	return s.eventPolicies[event]
+}
+
+// EventUnknownProperties 事件配置的未定义属性处理方式，未配置时为空字符串
+func (s *MetadataSnapshot) EventUnknownProperties(event string) string {
+	return s.eventUnknownProperties[event]
+}
+
+// Fields 所有字段元数据
+func (s *MetadataSnapshot) Fields() []dao.DbpField {
+	return s.fields
+}
+
+// FieldsByEvent 获取指定事件需要校验的字段元数据
+func (s *MetadataSnapshot) FieldsByEvent(event string) []dao.DbpField {
+	if fields, ok := s.eventFields[event]; ok {
+		return fields
+	}
+	return s.fields
+}
+
+// FieldEnumValueExists 判断枚举值是否存在
+func (s *MetadataSnapshot) FieldEnumValueExists(field string, value string) bool {
+	return s.enumValues[field][value]
+}
+
+// ProfileFields 所有用户属性字段元数据
+func (s *MetadataSnapshot) ProfileFields() []dao.DbpField {
+	return s.profileFields
+}
+
+// ItemFields 指定物品类型的字段元数据，物品类型未定义时返回 false
+func (s *MetadataSnapshot) ItemFields(itemType string) ([]dao.DbpField, bool) {
+	fields, ok := s.itemFields[itemType]
+	return fields, ok
+}
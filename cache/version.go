@@ -0,0 +1,188 @@
+package cache
+
+import (
+	"errors"
+	"liangck.xyz/data-service/sensors-log-acceptor/logger"
+	"liangck.xyz/data-service/sensors-log-acceptor/metrics"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 元数据版本号（方案二）
+// redis 中每张元数据表维护一个自增版本号，key：DBP:META_CACHE:version:{table}，修改元数据发送变更消息前先自增（incr）。
+// 元数据快照记录构建时的版本号，变更消息只是加快刷新，即使丢失（redis 重启、网络抖动），
+// 后台对账任务也会定期比较快照与 redis 的版本号，只重新加载版本号不一致的表。
+
+const VersionKeyPrefix = KeyPrefix + Version + KeyDelimiter
+
+const defaultReconcileInterval = time.Minute
+
+var errRedisNotInitialized = errors.New("redis is not initialized")
+
+// 元数据表
+var tables = []string{TableEvents, TableFields, TableEventFields, TableFieldEnumValues, TableProfileFields, TableItemFields}
+
+// 请求重新加载元数据，多个变更消息合并为一次加载
+var reloadCh = make(chan struct{}, 1)
+
+// 停止加载任务
+var stopReloader = make(chan struct{})
+var stopReloaderOnce sync.Once
+
+// TableVersion 元数据表本地缓存版本号与 redis 中的版本号
+type TableVersion struct {
+	Table  string
+	Local  int64
+	Remote int64
+}
+
+func getVersionKey(table string) string {
+	return VersionKeyPrefix + table
+}
+
+// incrVersion 元数据修改后自增 redis 中的版本号
+func incrVersion(table string) {
+	if err := redisClient.Incr(ctx, getVersionKey(table)).Err(); err != nil {
+		logger.Logger.Error("failed to incr version of " + table + ". caused by: " + err.Error())
+	}
+}
+
+// getRemoteVersions 获取 redis 中所有元数据表的版本号，未设置版本号的表为 0
+func getRemoteVersions() (map[string]int64, error) {
+	if redisClient == nil {
+		return nil, errRedisNotInitialized
+	}
+	keys := make([]string, 0, len(tables))
+	for _, table := range tables {
+		keys = append(keys, getVersionKey(table))
+	}
+	values, err := redisClient.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make(map[string]int64, len(tables))
+	for idx, table := range tables {
+		if value, ok := values[idx].(string); ok {
+			versions[table], _ = strconv.ParseInt(value, 10, 64)
+		}
+	}
+	return versions, nil
+}
+
+// reload 重新加载所有元数据表并构建快照，加载失败时保留之前的快照
+func reload() error {
+	return reloadTables(nil)
+}
+
+// reloadTables 重新加载 changed 中的表，其他表沿用之前快照的数据，加载失败时保留之前的快照；
+// changed 为空、来源不支持按表加载（文件）或还没有加载过元数据时加载所有表。
+// 只有变化的表从数据库读取，快照的索引（事件字段引用字段定义、子字段挂到 object 字段下等）跨表关联，
+// 合并后在内存中重新构建，快照构建后只读，不修改之前的快照。
+// 先读取版本号再加载，加载期间发生的修改版本号会大于快照记录的版本号，对账时重新加载
+func reloadTables(changed []string) error {
+	var versions map[string]int64
+	if Versioned() {
+		var err error
+		if versions, err = getRemoteVersions(); err != nil {
+			logger.Logger.Error("failed to get metadata versions. caused by: " + err.Error())
+		}
+	}
+	var metadata *Metadata
+	var err error
+	previous := Snapshot()
+	if loader, ok := provider.(tableProvider); ok && len(changed) > 0 && previous != emptySnapshot {
+		metadata, err = loader.LoadTables(previous.metadata, changed)
+	} else {
+		changed = tables
+		metadata, err = provider.Load()
+	}
+	if err != nil {
+		metrics.CacheRefreshErrorsTotal.Inc()
+		logger.Logger.Error("failed to load metadata, keep previous snapshot. caused by: " + err.Error())
+		return err
+	}
+
+	snapshot := NewMetadataSnapshot(metadata, versions)
+	currentSnapshot.Store(snapshot)
+	for _, table := range changed {
+		metrics.CacheRefreshed(table)
+	}
+	for _, table := range tables {
+		metrics.CacheVersion.WithLabelValues(table).Set(float64(snapshot.Versions[table]))
+	}
+	return nil
+}
+
+// requestReload 请求重新加载元数据，已有未处理的请求时忽略
+func requestReload() {
+	select {
+	case reloadCh <- struct{}{}:
+	default:
+	}
+}
+
+// reconcile 比较快照与 redis 的版本号，只重新加载版本号不一致的表
+func reconcile() {
+	versions, err := getRemoteVersions()
+	if err != nil {
+		logger.Logger.Error("failed to reconcile metadata versions. caused by: " + err.Error())
+		return
+	}
+	local := Snapshot().Versions
+	var changed []string
+	for _, table := range tables {
+		if local[table] != versions[table] {
+			logger.Logger.Info("metadata " + table + " version changed from " + strconv.FormatInt(local[table], 10) +
+				" to " + strconv.FormatInt(versions[table], 10) + ", reload")
+			changed = append(changed, table)
+		}
+	}
+	if len(changed) > 0 {
+		_ = reloadTables(changed)
+	}
+}
+
+// runReloader 处理重新加载请求，并按 interval 定期对账，interval 为 0 时不对账
+// 加载都在此 goroutine 中进行，不会并发加载，也不会出现旧数据覆盖新数据
+func runReloader(interval time.Duration) {
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-reloadCh:
+			_ = reload()
+		case <-tick:
+			reconcile()
+		case <-stopReloader:
+			return
+		}
+	}
+}
+
+// stopReloading 停止加载任务
+func stopReloading() {
+	stopReloaderOnce.Do(func() {
+		close(stopReloader)
+	})
+}
+
+// Versions 获取本节点所有元数据表的本地版本号及 redis 中的版本号，redis 不可用时 Remote 为 -1
+func Versions() []TableVersion {
+	local := Snapshot().Versions
+	remoteVersions, err := getRemoteVersions()
+	versions := make([]TableVersion, 0, len(tables))
+	for _, table := range tables {
+		version := TableVersion{Table: table, Local: local[table], Remote: -1}
+		if err == nil {
+			version.Remote = remoteVersions[table]
+		}
+		versions = append(versions, version)
+	}
+	return versions
+}
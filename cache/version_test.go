@@ -0,0 +1,77 @@
+package cache
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+	"liangck.xyz/data-service/sensors-log-acceptor/dao"
+	"liangck.xyz/data-service/sensors-log-acceptor/logger"
+	"testing"
+)
+
+func TestVersionsWithoutRedis(t *testing.T) {
+	logger.Logger = zap.NewNop()
+	ctx = context.TODO()
+	redisClient = redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1})
+	defer func() { redisClient = nil }()
+
+	currentSnapshot.Store(NewMetadataSnapshot(&Metadata{}, map[string]int64{TableFields: 3}))
+	versions := Versions()
+	if len(versions) != len(tables) {
+		t.Fatalf("should report versions of %d tables, got %d", len(tables), len(versions))
+	}
+	for _, version := range versions {
+		if version.Remote != -1 {
+			t.Errorf("remote version of %s should be -1 when redis is unavailable, got %d", version.Table, version.Remote)
+		}
+		if version.Table == TableFields && version.Local != 3 {
+			t.Errorf("local version of %s should be 3, got %d", version.Table, version.Local)
+		}
+	}
+
+	// redis 不可用时对账不重新加载
+	reconcile()
+	if Snapshot().Versions[TableFields] != 3 {
+		t.Error("snapshot should be kept when redis is unavailable")
+	}
+}
+
+// tablesProvider 记录按表加载请求的元数据来源
+type tablesProvider struct {
+	metadata *Metadata
+	loaded   []string
+}
+
+func (p *tablesProvider) Load() (*Metadata, error) {
+	return p.metadata, nil
+}
+
+func (p *tablesProvider) LoadTables(previous *Metadata, changed []string) (*Metadata, error) {
+	p.loaded = changed
+	metadata := *previous
+	metadata.Events = p.metadata.Events
+	return &metadata, nil
+}
+
+func TestReloadChangedTables(t *testing.T) {
+	logger.Logger = zap.NewNop()
+	previousProvider := provider
+	defer func() { provider = previousProvider }()
+
+	fake := &tablesProvider{metadata: &Metadata{Events: []dao.DbpEvent{{Event: "page_view"}}}}
+	provider = fake
+	currentSnapshot.Store(NewMetadataSnapshot(&Metadata{Events: []dao.DbpEvent{{Event: "app_start"}},
+		Fields: []dao.DbpField{{Field: "page_id", Type: "string"}}}, nil))
+
+	// 只重新加载变化的表，其他表沿用之前快照的数据
+	if err := reloadTables([]string{TableEvents}); err != nil {
+		t.Fatal(err)
+	}
+	snapshot := Snapshot()
+	if len(fake.loaded) != 1 || fake.loaded[0] != TableEvents {
+		t.Errorf("only %s should be loaded, got %v", TableEvents, fake.loaded)
+	}
+	if !snapshot.EventExists("page_view") || snapshot.EventExists("app_start") || len(snapshot.Fields()) != 1 {
+		t.Errorf("events should be reloaded and fields kept, got %+v", snapshot.metadata)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"github.com/Jeffail/gabs"
+	"github.com/gin-gonic/gin"
+	"liangck.xyz/data-service/sensors-log-acceptor/dao"
+	"liangck.xyz/data-service/sensors-log-acceptor/metrics"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 类型转换
+// 上报值类型与字段类型不一致时，尝试将上报值转换为字段类型：
+//	十进制数值字符串 -> int、long（只接受整数）、float、number
+//	"true"、"false"、"1"、"0"、1、0 -> bool
+//	数值、bool -> string、enum
+// 字段开启 coerce（dbp_fields.coerce）时，校验前先转换，转换记录在指标 fields_coerced_total 中（按字段和 sdk 类型统计），
+// 具体的 sdk 版本记录在进程内（最多 maxCoercedVersions 个，通过 /admin/coercedVersions 查询），用于定位上报错误类型的 sdk；
+// 未开启时，字段处理策略为 coerce 的字段在类型校验失败后转换。
+
+// 十进制数值：可选符号、整数部分和（或）小数部分、可选指数
+var decimalPattern = regexp.MustCompile(`^[+-]?([0-9]+(\.[0-9]*)?|\.[0-9]+)([eE][+-]?[0-9]+)?$`)
+
+// coerceValue 转换上报值为字段类型，上报值类型与字段类型一致或无法转换时返回 false
+func coerceValue(value interface{}, field dao.DbpField) (interface{}, bool) {
+	switch field.Type {
+	case TypeInt, TypeLong, TypeFloat, TypeNumber:
+		if str, ok := value.(string); ok {
+			return parseDecimal(str, field.Type == TypeInt || field.Type == TypeLong)
+		}
+	case TypeBool:
+		switch v := value.(type) {
+		case string:
+			switch v {
+			case "true", "1":
+				return true, true
+			case "false", "0":
+				return false, true
+			}
+		case float64:
+			if v == 1 || v == 0 {
+				return v == 1, true
+			}
+		}
+	case TypeString, TypeEnum:
+		switch v := value.(type) {
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64), true
+		case bool:
+			return strconv.FormatBool(v), true
+		}
+	}
+	return nil, false
+}
+
+// parseDecimal 解析十进制数值字符串，只接受普通的十进制写法（如 3、-1.5、2e3），
+// 不接受 NaN、Inf、十六进制、下划线分隔等 strconv.ParseFloat 支持的写法；integer 为 true 时只接受整数
+func parseDecimal(value string, integer bool) (interface{}, bool) {
+	value = strings.TrimSpace(value)
+	if !decimalPattern.MatchString(value) {
+		return nil, false
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(number) || math.IsInf(number, 0) {
+		return nil, false
+	}
+	if integer && number != math.Trunc(number) {
+		return nil, false
+	}
+	return number, true
+}
+
+// coerceField 字段开启了类型转换时转换上报值并记录指标，未转换时原样返回
+func coerceField(jsonParsed *gabs.Container, fieldValue interface{}, field dao.DbpField) interface{} {
+	if !field.Coerce {
+		return fieldValue
+	}
+	coerced, ok := coerceValue(fieldValue, field)
+	if !ok {
+		return fieldValue
+	}
+	lib, libVersion := sdkVersion(jsonParsed)
+	metrics.FieldsCoercedTotal.WithLabelValues(field.Field, jsonTypeName(fieldValue), field.Type, metrics.LibLabel(lib)).Inc()
+	recordCoercedVersion(field.Field, lib, libVersion)
+	return coerced
+}
+
+// 记录的 sdk 版本数上限、版本号最大长度，版本号取值不受控，超过上限后不再记录新的版本
+const maxCoercedVersions = 1000
+const maxVersionLength = 64
+
+// CoercedVersion 发生类型转换的字段和 sdk 版本
+type CoercedVersion struct {
+	Field      string `json:"field"`
+	Lib        string `json:"lib"`
+	LibVersion string `json:"libVersion"`
+	Count      int64  `json:"count"`
+}
+
+var coercedVersionsMu sync.Mutex
+var coercedVersions = make(map[CoercedVersion]int64)
+
+// recordCoercedVersion 累计字段和 sdk 版本的转换次数
+func recordCoercedVersion(field string, lib string, libVersion string) {
+	key := CoercedVersion{Field: field, Lib: truncateString(lib, maxVersionLength), LibVersion: truncateString(libVersion, maxVersionLength)}
+	coercedVersionsMu.Lock()
+	defer coercedVersionsMu.Unlock()
+	if _, ok := coercedVersions[key]; ok || len(coercedVersions) < maxCoercedVersions {
+		coercedVersions[key]++
+	}
+}
+
+// listCoercedVersions 查询本节点记录的发生类型转换的字段和 sdk 版本，按转换次数倒序
+func listCoercedVersions(c *gin.Context) {
+	coercedVersionsMu.Lock()
+	versions := make([]CoercedVersion, 0, len(coercedVersions))
+	for key, count := range coercedVersions {
+		key.Count = count
+		versions = append(versions, key)
+	}
+	coercedVersionsMu.Unlock()
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Count > versions[j].Count
+	})
+	c.JSON(http.StatusOK, gin.H{
+		"errno": "0",
+		"data":  versions,
+	})
+}
+
+// sdkVersion 上报数据的 sdk 类型及版本，object 子字段等无法获取时为 unknown
+func sdkVersion(jsonParsed *gabs.Container) (string, string) {
+	lib, ok := jsonParsed.Path(LibJsonPath).Data().(string)
+	if !ok || lib == "" {
+		lib = metrics.UnknownLib
+	}
+	libVersion, ok := jsonParsed.Path(LibVersionJsonPath).Data().(string)
+	if !ok || libVersion == "" {
+		libVersion = metrics.UnknownLib
+	}
+	return lib, libVersion
+}
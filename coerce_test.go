@@ -0,0 +1,87 @@
+package main
+
+import (
+	"github.com/Jeffail/gabs"
+	"liangck.xyz/data-service/sensors-log-acceptor/cache"
+	"liangck.xyz/data-service/sensors-log-acceptor/dao"
+	. "liangck.xyz/data-service/sensors-log-acceptor/model"
+	"strconv"
+	"testing"
+)
+
+func TestCoerceValue(t *testing.T) {
+	cases := []struct {
+		value     interface{}
+		fieldType string
+		expected  interface{}
+		ok        bool
+	}{
+		{"3", TypeInt, 3.0, true},
+		{" 1.5 ", TypeFloat, 1.5, true},
+		{"3a", TypeLong, nil, false},
+		{"3.7", TypeLong, nil, false},
+		{"2e3", TypeLong, 2000.0, true},
+		{"NaN", TypeFloat, nil, false},
+		{"-Inf", TypeNumber, nil, false},
+		{"1e400", TypeFloat, nil, false},
+		{"0x1p3", TypeFloat, nil, false},
+		{"1_0", TypeInt, nil, false},
+		{"true", TypeBool, true, true},
+		{"0", TypeBool, false, true},
+		{1.0, TypeBool, true, true},
+		{2.0, TypeBool, nil, false},
+		{"yes", TypeBool, nil, false},
+		{12.0, TypeString, "12", true},
+		{false, TypeEnum, "false", true},
+		{"a", TypeString, nil, false},
+		{3.0, TypeInt, nil, false},
+	}
+	for _, c := range cases {
+		coerced, ok := coerceValue(c.value, dao.DbpField{Type: c.fieldType})
+		if ok != c.ok || (ok && coerced != c.expected) {
+			t.Errorf("coerce %v to %s should be %v(%t), got %v(%t)", c.value, c.fieldType, c.expected, c.ok, coerced, ok)
+		}
+	}
+}
+
+func TestValidFieldWithCoerce(t *testing.T) {
+	handlerConf = &HandlerConf{}
+	snapshot := cache.NewMetadataSnapshot(&cache.Metadata{}, nil)
+	jsonParsed, _ := gabs.ParseJSON([]byte(`{"lib":{"$lib":"iOS","$lib_version":"4.0.1"},"properties":{"material_position":"3","is_login":"1","user_type":1}}`))
+
+	data := map[string]interface{}{}
+	field := dao.DbpField{Field: "material_position", JsonPath: "properties.material_position", Type: TypeInt, Length: 8}
+	if validResult := validField(snapshot, jsonParsed, &data, field); validResult.OK || validResult.ErrType != TypeMisMatch {
+		t.Fatalf("numeric string should mismatch without coerce, got %v", validResult)
+	}
+	field.Coerce = true
+	if validResult := validField(snapshot, jsonParsed, &data, field); !validResult.OK || data["material_position"] != 3 {
+		t.Fatalf("numeric string should be coerced, got %v, %v", validResult, data["material_position"])
+	}
+	boolField := dao.DbpField{Field: "is_login", JsonPath: "properties.is_login", Type: TypeBool, Length: 8, Coerce: true}
+	if validResult := validField(snapshot, jsonParsed, &data, boolField); !validResult.OK || data["is_login"] != true {
+		t.Fatalf("\"1\" should be coerced to true, got %v, %v", validResult, data["is_login"])
+	}
+
+	// 枚举字段上报数值不再 panic
+	enumField := dao.DbpField{Field: "user_type", JsonPath: "properties.user_type", Type: TypeEnum, Length: 8}
+	if validResult := validField(snapshot, jsonParsed, &data, enumField); validResult.OK || validResult.ErrType != TypeMisMatch {
+		t.Fatalf("number of enum field should mismatch, got %v", validResult)
+	}
+}
+
+func TestRecordCoercedVersion(t *testing.T) {
+	coercedVersions = make(map[CoercedVersion]int64)
+	defer func() { coercedVersions = make(map[CoercedVersion]int64) }()
+
+	for i := 0; i < maxCoercedVersions+10; i++ {
+		recordCoercedVersion("material_position", "iOS", strconv.Itoa(i))
+	}
+	recordCoercedVersion("material_position", "iOS", "0")
+	if len(coercedVersions) != maxCoercedVersions {
+		t.Fatalf("expected %d versions, got %d", maxCoercedVersions, len(coercedVersions))
+	}
+	if count := coercedVersions[CoercedVersion{Field: "material_position", Lib: "iOS", LibVersion: "0"}]; count != 2 {
+		t.Errorf("expected count 2 for recorded version, got %d", count)
+	}
+}
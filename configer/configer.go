@@ -11,22 +11,61 @@ import (
 
 const ServiceName = "service.name"
 const ServiceAddress = "service.address"
+const ShutdownTimeout = "service.shutdownTimeout"
+const CorsAllowOrigins = "cors.allowOrigins"
 const RedisAddr = "redis.addr"
 const RedisPassword = "redis.password"
 const RedisDB = "redis.db"
+const CacheReconcileInterval = "cache.reconcileInterval"
+const MetadataSource = "metadata.source"
+const MetadataFile = "metadata.file"
 const DBUrl = "db.url"
 const KafkaBrokers = "kafka.brokers"
 const KafkaLogMsgTopic = "kafka.msgTopic"
 const kafkaErrMsgTopic = "kafka.errTopic"
+const KafkaErrTopicRoutes = "kafka.errTopicRoutes"
+const KafkaProfileTopic = "kafka.profileTopic"
+const KafkaItemTopic = "kafka.itemTopic"
+const KafkaLinkTopic = "kafka.linkTopic"
+const KafkaTrackDataType = "kafka.trackDataType"
+const KafkaMessageKey = "kafka.messageKey.default"
+const KafkaEventMessageKeys = "kafka.messageKey.events"
+const SpoolEnable = "spool.enable"
+const SpoolDir = "spool.dir"
+const SpoolSegmentMaxSize = "spool.segmentMaxSize"
+const SpoolMaxSize = "spool.maxSize"
+const SpoolReplayInterval = "spool.replayInterval"
 const LoggerConsoleEnable = "logger.console.enable"
 const LoggerFileEnable = "logger.file.enable"
 const LoggerKafkaEnable = "logger.kafka.enable"
+const LoggerKafkaBrokers = "logger.kafka.brokers"
+const LoggerKafkaTopic = "logger.kafka.topic"
+const LoggerKafkaBufferSize = "logger.kafka.bufferSize"
+const LoggerKafkaBatchSize = "logger.kafka.batchSize"
+const LoggerKafkaBatchTimeout = "logger.kafka.batchTimeout"
 const LoggerFilePath = "logger.file.path"
 const LoggerFileMaxAge = "logger.file.maxAge"
 const LoggerFileMaxSize = "logger.file.maxSize"
 const LoggerFileMaxBackups = "logger.file.maxBackups"
 const LoggerFileCompress = "logger.file.compress"
 const LoggerEnableLevel = "logger.enableLevel"
+const LoggerPayloadSamplingFirst = "logger.payloadSampling.first"
+const LoggerPayloadSamplingThereafter = "logger.payloadSampling.thereafter"
+const IdentityEnable = "identity.enable"
+const IdentityTTL = "identity.ttl"
+const IdentityTimeout = "identity.timeout"
+const AdminAddress = "admin.address"
+const AdminTokens = "admin.tokens"
+const AdminHmacKeys = "admin.hmacKeys"
+const AdminHmacMaxSkew = "admin.hmacMaxSkew"
+const CrcMode = "crc.mode"
+const ValidationMode = "validation.mode"
+const ValidationDatetimeLayouts = "validation.datetimeLayouts"
+const ValidationDateLayouts = "validation.dateLayouts"
+const ValidationPolicy = "validation.policy"
+const ValidationUnknownProperties = "validation.unknownProperties"
+const DiscoveryFlushInterval = "discovery.flushInterval"
+const DiscoveryMaxEntries = "discovery.maxEntries"
 const ConsulAddress = "consul.address"
 const Env = "env"
 
@@ -41,10 +80,22 @@ type Config struct {
 	// service address
 	ServiceAddress string // 服务地址，格式    :port
 
+	// 优雅退出超时时间，单位 秒
+	ShutdownTimeout int
+
+	// cors
+	CorsAllowOrigins string // 允许跨域上报的 Origin，逗号分隔，为空则允许所有（不允许携带 cookie）
+
 	// cache
 	RedisAddr     string
 	RedisPassword string
 	RedisDB       int
+	// 元数据版本号对账间隔，单位 秒
+	CacheReconcileInterval int
+
+	// 元数据来源：db（默认）、file
+	MetadataSource string
+	MetadataFile   string // 元数据文件路径（YAML/JSON），source 为 file 时使用
 
 	// db
 	DBUrl string // 数据库连接地址
@@ -53,17 +104,73 @@ type Config struct {
 	KafkaBrokers     string
 	KafkaLogMsgTopic string
 	KafkaErrMsgTopic string
+	// 按错误类型（model.ErrType 名称）路由异常信息的 topic，未配置的错误类型发送至 KafkaErrMsgTopic
+	KafkaErrTopicRoutes map[string]string
+	KafkaProfileTopic   string // 用户属性数据（profile_*）topic
+	KafkaItemTopic      string // 物品数据（item_*）topic
+	KafkaLinkTopic      string // id 关联（track_signup）事件 topic
+	// 行为事件数据是否输出数据类型字段 type（track、track_signup），默认不输出
+	KafkaTrackDataType bool
+	// 消息 key 使用的字段，默认 distinct_id
+	KafkaMessageKey string
+	// 按事件覆盖消息 key 使用的字段
+	KafkaEventMessageKeys map[string]string
+
+	// spool，kafka 不可用时本地落盘
+	SpoolEnable         bool
+	SpoolDir            string // 落盘目录
+	SpoolSegmentMaxSize int    // 单个分段文件最大大小，单位 MB
+	SpoolMaxSize        int    // 落盘数据最大大小，单位 MB，超过后丢弃
+	SpoolReplayInterval int    // 回放间隔，单位 秒
 
 	// logger
-	LoggerConsoleEnable bool
-	LoggerFileEnable    bool
-	LoggerKafkaEnable   bool
-	LogFileMaxAge       int
-	LogFileMaxSize      int
-	LogFileMaxBackups   int
-	LogFilePath         string
-	LogFileCompress     bool
-	LoggerEnableLevel   string
+	LoggerConsoleEnable     bool
+	LoggerFileEnable        bool
+	LoggerKafkaEnable       bool
+	LoggerKafkaBrokers      string
+	LoggerKafkaTopic        string
+	LoggerKafkaBufferSize   int // 缓冲区大小（日志条数）
+	LoggerKafkaBatchSize    int // 批量发送条数
+	LoggerKafkaBatchTimeout int // 批量发送最大等待时间，单位 毫秒
+	LogFileMaxAge           int
+	LogFileMaxSize          int
+	LogFileMaxBackups       int
+	LogFilePath             string
+	LogFileCompress         bool
+	LoggerEnableLevel       string
+	// 上报数据日志采样：每秒前 first 条全部输出，之后每 thereafter 条输出一条
+	LoggerPayloadSamplingFirst      int
+	LoggerPayloadSamplingThereafter int
+
+	// id 关联：track_signup 关联匿名 id 与登录 id，数据中补充 user_key
+	IdentityEnable bool
+	IdentityTTL    int // 关联关系过期时间，单位 天，0 为永不过期
+	// 查询关联关系的 redis 连接、读写超时时间，单位 毫秒
+	IdentityTimeout int
+
+	// 管理接口
+	AdminAddress     string            // 管理接口单独的监听地址，为空时与 ServiceAddress 相同
+	AdminTokens      map[string]string // 调用方名称 -> bearer token
+	AdminHmacKeys    map[string]string // 调用方名称 -> HMAC 签名密钥
+	AdminHmacMaxSkew int               // HMAC 签名时间戳允许的最大偏差，单位 秒
+
+	// crc 校验模式：off、flag、reject
+	CrcMode string
+
+	// 字段校验模式：failFast（默认）、collectAll
+	ValidationMode string
+	// datetime、date 类型字段支持的时间格式（go time layout）
+	ValidationDatetimeLayouts []string
+	ValidationDateLayouts     []string
+	// 字段校验失败时的默认处理策略：reject（默认）、drop_field、null_field、truncate、coerce
+	ValidationPolicy string
+	// 未定义属性的默认处理方式：ignore（默认）、strict、passthrough、discover
+	ValidationUnknownProperties string
+
+	// 发现记录（未定义属性等）写入数据库的间隔，单位 秒
+	DiscoveryFlushInterval int
+	// 每个间隔内内存中最多聚合的发现记录数，超过后丢弃新记录
+	DiscoveryMaxEntries int
 }
 
 func Init() *Config {
@@ -95,28 +202,76 @@ func Init() *Config {
 	}
 
 	return &Config{
-		ServiceName:    GetString(ServiceName),
-		ServiceAddress: GetString(ServiceAddress),
+		ServiceName:     GetString(ServiceName),
+		ServiceAddress:  GetString(ServiceAddress),
+		ShutdownTimeout: GetInt(ShutdownTimeout),
+		// cors
+		CorsAllowOrigins: GetString(CorsAllowOrigins),
 		// redis
 		RedisAddr:     GetString(RedisAddr),
 		RedisPassword: GetString(RedisPassword),
 		RedisDB:       GetInt(RedisDB),
+		// cache
+		CacheReconcileInterval: GetInt(CacheReconcileInterval),
+		// metadata
+		MetadataSource: GetString(MetadataSource),
+		MetadataFile:   GetString(MetadataFile),
 		// db
 		DBUrl: GetString(DBUrl),
 		// kafka
-		KafkaBrokers:     GetString(KafkaBrokers),
-		KafkaLogMsgTopic: GetString(KafkaLogMsgTopic),
-		KafkaErrMsgTopic: GetString(kafkaErrMsgTopic),
+		KafkaBrokers:          GetString(KafkaBrokers),
+		KafkaLogMsgTopic:      GetString(KafkaLogMsgTopic),
+		KafkaErrMsgTopic:      GetString(kafkaErrMsgTopic),
+		KafkaErrTopicRoutes:   GetStringMapString(KafkaErrTopicRoutes),
+		KafkaProfileTopic:     GetString(KafkaProfileTopic),
+		KafkaItemTopic:        GetString(KafkaItemTopic),
+		KafkaLinkTopic:        GetString(KafkaLinkTopic),
+		KafkaTrackDataType:    GetBool(KafkaTrackDataType),
+		KafkaMessageKey:       GetString(KafkaMessageKey),
+		KafkaEventMessageKeys: GetStringMapString(KafkaEventMessageKeys),
+		// spool
+		SpoolEnable:         GetBool(SpoolEnable),
+		SpoolDir:            GetString(SpoolDir),
+		SpoolSegmentMaxSize: GetInt(SpoolSegmentMaxSize),
+		SpoolMaxSize:        GetInt(SpoolMaxSize),
+		SpoolReplayInterval: GetInt(SpoolReplayInterval),
 		// log
-		LoggerConsoleEnable: GetBool(LoggerConsoleEnable),
-		LoggerFileEnable:    GetBool(LoggerFileEnable),
-		LoggerKafkaEnable:   GetBool(LoggerKafkaEnable),
-		LogFileMaxAge:       GetInt(LoggerFileMaxAge),
-		LogFileMaxSize:      GetInt(LoggerFileMaxSize),
-		LogFileMaxBackups:   GetInt(LoggerFileMaxBackups),
-		LogFilePath:         GetString(LoggerFilePath),
-		LogFileCompress:     GetBool(LoggerFileCompress),
-		LoggerEnableLevel:   GetString(LoggerEnableLevel),
+		LoggerConsoleEnable:             GetBool(LoggerConsoleEnable),
+		LoggerFileEnable:                GetBool(LoggerFileEnable),
+		LoggerKafkaEnable:               GetBool(LoggerKafkaEnable),
+		LoggerKafkaBrokers:              GetString(LoggerKafkaBrokers),
+		LoggerKafkaTopic:                GetString(LoggerKafkaTopic),
+		LoggerKafkaBufferSize:           GetInt(LoggerKafkaBufferSize),
+		LoggerKafkaBatchSize:            GetInt(LoggerKafkaBatchSize),
+		LoggerKafkaBatchTimeout:         GetInt(LoggerKafkaBatchTimeout),
+		LogFileMaxAge:                   GetInt(LoggerFileMaxAge),
+		LogFileMaxSize:                  GetInt(LoggerFileMaxSize),
+		LogFileMaxBackups:               GetInt(LoggerFileMaxBackups),
+		LogFilePath:                     GetString(LoggerFilePath),
+		LogFileCompress:                 GetBool(LoggerFileCompress),
+		LoggerEnableLevel:               GetString(LoggerEnableLevel),
+		LoggerPayloadSamplingFirst:      GetInt(LoggerPayloadSamplingFirst),
+		LoggerPayloadSamplingThereafter: GetInt(LoggerPayloadSamplingThereafter),
+		// identity
+		IdentityEnable:  GetBool(IdentityEnable),
+		IdentityTTL:     GetInt(IdentityTTL),
+		IdentityTimeout: GetInt(IdentityTimeout),
+		// admin
+		AdminAddress:     GetString(AdminAddress),
+		AdminTokens:      GetStringMapString(AdminTokens),
+		AdminHmacKeys:    GetStringMapString(AdminHmacKeys),
+		AdminHmacMaxSkew: GetInt(AdminHmacMaxSkew),
+		// crc
+		CrcMode: GetString(CrcMode),
+		// validation
+		ValidationMode:              GetString(ValidationMode),
+		ValidationDatetimeLayouts:   GetStringSlice(ValidationDatetimeLayouts),
+		ValidationDateLayouts:       GetStringSlice(ValidationDateLayouts),
+		ValidationPolicy:            GetString(ValidationPolicy),
+		ValidationUnknownProperties: GetString(ValidationUnknownProperties),
+		// discovery
+		DiscoveryFlushInterval: GetInt(DiscoveryFlushInterval),
+		DiscoveryMaxEntries:    GetInt(DiscoveryMaxEntries),
 	}
 }
 
@@ -142,3 +297,17 @@ func GetBool(key string) bool {
 	}
 	return DefaultViper.GetBool(key)
 }
+
+func GetStringSlice(key string) []string {
+	if ConsulViper.IsSet(key) {
+		return ConsulViper.GetStringSlice(key)
+	}
+	return DefaultViper.GetStringSlice(key)
+}
+
+func GetStringMapString(key string) map[string]string {
+	if ConsulViper.IsSet(key) {
+		return ConsulViper.GetStringMapString(key)
+	}
+	return DefaultViper.GetStringMapString(key)
+}
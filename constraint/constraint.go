@@ -0,0 +1,185 @@
+package constraint
+
+import (
+	"encoding/json"
+	"errors"
+	. "liangck.xyz/data-service/sensors-log-acceptor/model"
+	"net"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// 字段值约束
+// 字段定义（dbp_fields.constraints）和事件字段配置（dbp_event_fields.constraints）中以 json 保存，如：
+//	{"min": -90, "max": 90}
+//	{"regex": "^[a-z_]+$", "minLength": 1}
+//	{"format": "url"}
+// 事件字段配置了约束时，替换字段定义的约束。
+// 约束在构建元数据快照时编译（正则只编译一次），对类型校验、转换后的值进行校验：
+// min、max 校验数值（datetime 为毫秒时间戳），regex、format、minLength 校验字符串，list 逐个校验元素。
+
+const FormatUrl = "url"
+const FormatEmail = "email"
+const FormatUuid = "uuid"
+const FormatIpv4 = "ipv4"
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// 支持的字符串格式
+var formats = map[string]func(string) bool{
+	FormatUrl:   isUrl,
+	FormatEmail: isEmail,
+	FormatUuid:  uuidPattern.MatchString,
+	FormatIpv4:  isIpv4,
+}
+
+// Constraints 字段值约束定义
+type Constraints struct {
+	Min       *float64 `json:"min,omitempty" yaml:"min,omitempty"`
+	Max       *float64 `json:"max,omitempty" yaml:"max,omitempty"`
+	Regex     string   `json:"regex,omitempty" yaml:"regex,omitempty"`
+	Format    string   `json:"format,omitempty" yaml:"format,omitempty"`
+	MinLength int      `json:"minLength,omitempty" yaml:"minLength,omitempty"`
+}
+
+// Rules 编译后的约束
+type Rules struct {
+	Constraints
+	regex  *regexp.Regexp
+	format func(string) bool
+}
+
+// Parse 解析 json 格式的约束，空字符串返回 nil
+func Parse(raw string) (*Constraints, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	var constraints Constraints
+	if err := json.Unmarshal([]byte(raw), &constraints); err != nil {
+		return nil, err
+	}
+	return &constraints, nil
+}
+
+// String 约束的 json 格式，没有约束时为空字符串
+func (c *Constraints) String() string {
+	if c == nil || *c == (Constraints{}) {
+		return ""
+	}
+	marshaled, _ := json.Marshal(c)
+	return string(marshaled)
+}
+
+// Normalize 统一约束 json 的格式（key 顺序、空格），用于比较约束是否相同，无法解析时原样返回
+func Normalize(raw string) string {
+	constraints, err := Parse(raw)
+	if err != nil {
+		return raw
+	}
+	return constraints.String()
+}
+
+// Compile 编译约束，没有约束时返回 nil
+func (c *Constraints) Compile() (*Rules, error) {
+	if c.String() == "" {
+		return nil, nil
+	}
+	if c.Min != nil && c.Max != nil && *c.Min > *c.Max {
+		return nil, errors.New("min can not be greater than max")
+	}
+	if c.MinLength < 0 {
+		return nil, errors.New("minLength can not be negative")
+	}
+	rules := &Rules{Constraints: *c}
+	if c.Regex != "" {
+		regex, err := regexp.Compile(c.Regex)
+		if err != nil {
+			return nil, err
+		}
+		rules.regex = regex
+	}
+	if c.Format != "" {
+		format, ok := formats[c.Format]
+		if !ok {
+			return nil, errors.New("unsupported format [" + c.Format + "]")
+		}
+		rules.format = format
+	}
+	return rules, nil
+}
+
+// Compile 解析并编译 json 格式的约束，没有约束时返回 nil
+func Compile(raw string) (*Rules, error) {
+	constraints, err := Parse(raw)
+	if err != nil || constraints == nil {
+		return nil, err
+	}
+	return constraints.Compile()
+}
+
+// Check 校验字段值，value 为类型校验、转换后的值
+func (r *Rules) Check(field string, value interface{}) *ValidResult {
+	switch v := value.(type) {
+	case []interface{}:
+		for idx, element := range v {
+			if validResult := r.Check(field+"["+strconv.Itoa(idx)+"]", element); !validResult.OK {
+				return validResult
+			}
+		}
+	case string:
+		return r.checkString(field, v)
+	case float64:
+		return r.checkNumber(field, v)
+	case int:
+		return r.checkNumber(field, float64(v))
+	case int64:
+		return r.checkNumber(field, float64(v))
+	}
+	return &ValidResult{OK: true, ErrType: None}
+}
+
+func (r *Rules) checkNumber(field string, value float64) *ValidResult {
+	if r.Min != nil && value < *r.Min {
+		return &ValidResult{OK: false, Err: "field: " + field + " value " + formatFloat(value) + " less than " + formatFloat(*r.Min), ErrType: ValueOutOfRange}
+	}
+	if r.Max != nil && value > *r.Max {
+		return &ValidResult{OK: false, Err: "field: " + field + " value " + formatFloat(value) + " greater than " + formatFloat(*r.Max), ErrType: ValueOutOfRange}
+	}
+	return &ValidResult{OK: true, ErrType: None}
+}
+
+func (r *Rules) checkString(field string, value string) *ValidResult {
+	if r.MinLength > 0 && utf8.RuneCountInString(value) < r.MinLength {
+		return &ValidResult{OK: false, Err: "field: " + field + " value length less than " + strconv.Itoa(r.MinLength), ErrType: ValueTooShort}
+	}
+	if r.regex != nil && !r.regex.MatchString(value) {
+		return &ValidResult{OK: false, Err: "field: " + field + " value [" + value + "] does not match " + r.Regex, ErrType: PatternMismatch}
+	}
+	if r.format != nil && !r.format(value) {
+		return &ValidResult{OK: false, Err: "field: " + field + " value [" + value + "] is not a valid " + r.Format, ErrType: FormatMismatch}
+	}
+	return &ValidResult{OK: true, ErrType: None}
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+func isUrl(value string) bool {
+	u, err := url.ParseRequestURI(value)
+	return err == nil && u.Scheme != "" && u.Host != ""
+}
+
+func isEmail(value string) bool {
+	address, err := mail.ParseAddress(value)
+	return err == nil && address.Address == value
+}
+
+func isIpv4(value string) bool {
+	ip := net.ParseIP(value)
+	return ip != nil && ip.To4() != nil && strings.Contains(value, ".")
+}
@@ -0,0 +1,53 @@
+package constraint
+
+import (
+	. "liangck.xyz/data-service/sensors-log-acceptor/model"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	cases := []struct {
+		constraints string
+		value       interface{}
+		errType     ErrType
+	}{
+		{`{"min": -90, "max": 90}`, 31.2, None},
+		{`{"min": -90, "max": 90}`, 91.0, ValueOutOfRange},
+		{`{"min": 0}`, int64(-1), ValueOutOfRange},
+		{`{"regex": "^[a-z_]+$"}`, "page_view", None},
+		{`{"regex": "^[a-z_]+$"}`, "PageView", PatternMismatch},
+		{`{"regex": "^\\d+\\.\\d+\\.\\d+$"}`, "1.2", PatternMismatch},
+		{`{"minLength": 2}`, "a", ValueTooShort},
+		{`{"format": "url"}`, "https://liangck.xyz/a?b=1", None},
+		{`{"format": "url"}`, "liangck.xyz", FormatMismatch},
+		{`{"format": "email"}`, "a@liangck.xyz", None},
+		{`{"format": "email"}`, "a@", FormatMismatch},
+		{`{"format": "uuid"}`, "0b7f1c2e-3d4a-4b5c-8d9e-0f1a2b3c4d5e", None},
+		{`{"format": "ipv4"}`, "10.0.0.1", None},
+		{`{"format": "ipv4"}`, "::1", FormatMismatch},
+		{`{"format": "url"}`, []interface{}{"https://a.com", "b"}, FormatMismatch},
+	}
+	for _, c := range cases {
+		rules, err := Compile(c.constraints)
+		if err != nil {
+			t.Fatalf("%s should compile, got %v", c.constraints, err)
+		}
+		if validResult := rules.Check("f", c.value); validResult.ErrType != c.errType {
+			t.Errorf("%s check %v should be %s, got %s(%s)", c.constraints, c.value, c.errType, validResult.ErrType, validResult.Err)
+		}
+	}
+}
+
+func TestCompile(t *testing.T) {
+	if rules, err := Compile(""); rules != nil || err != nil {
+		t.Errorf("empty constraints should compile to nil, got %v, %v", rules, err)
+	}
+	for _, invalid := range []string{`{"regex": "("}`, `{"format": "phone"}`, `{"min": 2, "max": 1}`, `{"min":`} {
+		if _, err := Compile(invalid); err == nil {
+			t.Errorf("%s should be invalid", invalid)
+		}
+	}
+	if normalized := Normalize(`{ "max": 90, "min": -90 }`); normalized != `{"min":-90,"max":90}` {
+		t.Errorf("unexpected normalized constraints %s", normalized)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"liangck.xyz/data-service/sensors-log-acceptor/kafka"
+	. "liangck.xyz/data-service/sensors-log-acceptor/model"
+	"net/url"
+	"strconv"
+	"unicode/utf16"
+)
+
+// crc 校验模式
+const CrcModeOff = "off"       // 不校验
+const CrcModeFlag = "flag"     // 校验失败时发送异常信息至异常 Topic，数据继续处理
+const CrcModeReject = "reject" // 校验失败时发送异常信息至异常 Topic，并丢弃数据
+
+// sensorsHashCode 神策 SDK 使用的 crc 算法，与 java String.hashCode 一致（基于 UTF-16 编码单元计算）
+// js sdk 对 base64 后的 data 计算，android、ios sdk 对 base64 后的 data_list 计算
+func sensorsHashCode(s string) int32 {
+	var hash int32
+	for _, c := range utf16.Encode([]rune(s)) {
+		hash = 31*hash + int32(c)
+	}
+	return hash
+}
+
+// getRequestCrc 获取上报的 crc，android、ios sdk 使用 crc 参数，js sdk 放在 ext 参数中（ext=crc=xxx）
+func getRequestCrc(log *Log) string {
+	if log.Crc != "" {
+		return log.Crc
+	}
+	if log.Ext != "" {
+		if ext, err := url.ParseQuery(log.Ext); err == nil {
+			return ext.Get("crc")
+		}
+	}
+	return ""
+}
+
+// verifyCrc 校验上报数据的 crc，未上报 crc（旧版本 sdk）时不校验
+func verifyCrc(log *Log) *ValidResult {
+	if handlerConf.CrcMode == "" || handlerConf.CrcMode == CrcModeOff {
+		return &ValidResult{OK: true, ErrType: None}
+	}
+	crc := getRequestCrc(log)
+	if crc == "" {
+		return &ValidResult{OK: true, ErrType: None}
+	}
+
+	payload := log.Data
+	if log.Gzip != "" {
+		payload = log.DataList
+	}
+	expected := strconv.Itoa(int(sensorsHashCode(payload)))
+	if crc == expected {
+		return &ValidResult{OK: true, ErrType: None}
+	}
+
+	validResult := &ValidResult{OK: false, Err: "crc mismatch. request crc is " + crc + " and computed crc is " + expected, ErrType: CrcMismatch}
+	kafka.WriteErrorMsg(&ReportError{Err: validResult.Err, ErrType: validResult.ErrType, Data: payload})
+	if handlerConf.CrcMode == CrcModeFlag {
+		// 仅标记，数据继续处理
+		return &ValidResult{OK: true, Err: validResult.Err, ErrType: CrcMismatch}
+	}
+
+	return validResult
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"liangck.xyz/data-service/sensors-log-acceptor/model"
+	"testing"
+)
+
+func TestSensorsHashCode(t *testing.T) {
+	cases := map[string]int32{
+		"":      0,
+		"hello": 99162322,
+		"中文":    646394,
+		// 溢出后与 java int 一致
+		"eyJldmVudCI6InBhZ2VfdmlldyJ9": -1769400950,
+	}
+	for s, expected := range cases {
+		if actual := sensorsHashCode(s); actual != expected {
+			t.Errorf("hash of %q should be %d, got %d", s, expected, actual)
+		}
+	}
+}
+
+func TestGetRequestCrc(t *testing.T) {
+	if crc := getRequestCrc(&model.Log{Crc: "123", Ext: "crc=456"}); crc != "123" {
+		t.Errorf("crc param should take precedence, got %s", crc)
+	}
+	if crc := getRequestCrc(&model.Log{Ext: "crc=-456"}); crc != "-456" {
+		t.Errorf("crc should be read from ext, got %s", crc)
+	}
+	if crc := getRequestCrc(&model.Log{}); crc != "" {
+		t.Errorf("crc should be empty, got %s", crc)
+	}
+}
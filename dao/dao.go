@@ -1,12 +1,16 @@
 package dao
 
 import (
+	"encoding/json"
 	"fmt"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"liangck.xyz/data-service/sensors-log-acceptor/configer"
+	"liangck.xyz/data-service/sensors-log-acceptor/constraint"
 	"liangck.xyz/data-service/sensors-log-acceptor/logger"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -20,8 +24,12 @@ type DbpEvent struct {
 	ID          uint
 	Event       string
 	Description string
-	CreatedAt   time.Time
-	UpdatedAt   time.Time
+	// 字段校验失败时的默认处理策略，为空时使用全局配置（validation.policy）
+	Policy string
+	// 未定义属性的处理方式：ignore、strict、passthrough、discover，为空时使用全局配置（validation.unknownProperties）
+	UnknownProperties string
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
 }
 
 func (e DbpEvent) String() string {
@@ -32,15 +40,30 @@ func (e DbpEvent) String() string {
 // DbpField 字段定义
 type DbpField struct {
 	gorm.Model
-	ID        uint
-	Field     string
-	JsonPath  string
-	Type      string
-	Length    int
-	Name      string
-	Nullable  bool
+	ID       uint
+	Field    string
+	JsonPath string
+	Type     string
+	Length   int
+	Name     string
+	Nullable bool
+	// list 类型元素类型（默认 string）、最大元素个数（0 为不限制）
+	ElementType string
+	MaxElements int
+	// object 类型子字段所属的 object 字段，子字段的 JsonPath 为相对 object 的路径
+	Parent string
+	// 字段值约束（json），见 constraint 包
+	Constraints string
+	// 校验失败时的处理策略，为空时使用事件的策略
+	Policy string
+	// 上报值类型与字段类型不一致时是否先转换类型（如 "3" -> 3）
+	Coerce    bool
 	CreatedAt time.Time
 	UpdatedAt time.Time
+
+	// object 类型的子字段、编译后的字段值约束，构建元数据快照时填充，不对应数据库列
+	Children []DbpField        `gorm:"-"`
+	Rules    *constraint.Rules `gorm:"-"`
 }
 
 func (f DbpField) String() string {
@@ -51,10 +74,14 @@ func (f DbpField) String() string {
 // DbpEventField 事件字段配置
 type DbpEventField struct {
 	gorm.Model
-	ID        uint
-	Event     string
-	Field     string
-	Nullable  bool
+	ID       uint
+	Event    string
+	Field    string
+	Nullable bool
+	// 字段值约束（json），配置时替换字段定义的约束
+	Constraints string
+	// 校验失败时的处理策略，配置时替换字段定义的策略
+	Policy    string
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
@@ -80,6 +107,140 @@ func (fev DbpFieldEnumValue) String() string {
 		fev.ID, fev.Field, fev.EnumValue, fev.ValueName, fev.CreatedAt, fev.UpdatedAt)
 }
 
+// DbpProfileField 用户属性（profile_* 类型数据）字段定义
+type DbpProfileField struct {
+	gorm.Model
+	ID          uint
+	Field       string
+	JsonPath    string
+	Type        string
+	Length      int
+	Name        string
+	Nullable    bool
+	ElementType string
+	MaxElements int
+	Parent      string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+func (pf DbpProfileField) String() string {
+	return fmt.Sprintf("{ID: %d, Field: %s, JsonPath: %s, Type: %s, Length: %d, Name: %s, Nullable: %t, CreatedAt: %s, UpdatedAt: %s}",
+		pf.ID, pf.Field, pf.JsonPath, pf.Type, pf.Length, pf.Name, pf.Nullable, pf.CreatedAt, pf.UpdatedAt)
+}
+
+// ToDbpField 转换为字段定义，复用字段校验逻辑
+func (pf DbpProfileField) ToDbpField() DbpField {
+	return DbpField{ID: pf.ID, Field: pf.Field, JsonPath: pf.JsonPath, Type: pf.Type, Length: pf.Length, Name: pf.Name, Nullable: pf.Nullable,
+		ElementType: pf.ElementType, MaxElements: pf.MaxElements, Parent: pf.Parent}
+}
+
+// DbpItemField 物品（item_* 类型数据）字段定义，按物品类型配置
+type DbpItemField struct {
+	gorm.Model
+	ID          uint
+	ItemType    string
+	Field       string
+	JsonPath    string
+	Type        string
+	Length      int
+	Name        string
+	Nullable    bool
+	ElementType string
+	MaxElements int
+	Parent      string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+func (itf DbpItemField) String() string {
+	return fmt.Sprintf("{ID: %d, ItemType: %s, Field: %s, JsonPath: %s, Type: %s, Length: %d, Name: %s, Nullable: %t, CreatedAt: %s, UpdatedAt: %s}",
+		itf.ID, itf.ItemType, itf.Field, itf.JsonPath, itf.Type, itf.Length, itf.Name, itf.Nullable, itf.CreatedAt, itf.UpdatedAt)
+}
+
+// ToDbpField 转换为字段定义，复用字段校验逻辑
+func (itf DbpItemField) ToDbpField() DbpField {
+	return DbpField{ID: itf.ID, Field: itf.Field, JsonPath: itf.JsonPath, Type: itf.Type, Length: itf.Length, Name: itf.Name, Nullable: itf.Nullable,
+		ElementType: itf.ElementType, MaxElements: itf.MaxElements, Parent: itf.Parent}
+}
+
+// DbpDiscoveredField 未定义属性发现记录（事件未定义属性的处理方式为 discover 时记录），供人工审核后补充字段定义
+// （事件, 属性）唯一，多个实例的记录合并到同一行
+type DbpDiscoveredField struct {
+	gorm.Model
+	ID    uint
+	Event string `gorm:"size:255;uniqueIndex:uidx_dbp_discovered_fields_event_field"`
+	Field string `gorm:"size:255;uniqueIndex:uidx_dbp_discovered_fields_event_field"`
+	// 推断的字段类型、最近一次上报的示例值（json）
+	Type        string
+	SampleValue string
+	// 累计上报次数，首次、最近一次上报时间
+	Count       int64
+	FirstSeenAt time.Time
+	LastSeenAt  time.Time
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+func (df DbpDiscoveredField) String() string {
+	return fmt.Sprintf("{ID: %d, Event: %s, Field: %s, Type: %s, SampleValue: %s, Count: %d, FirstSeenAt: %s, LastSeenAt: %s}",
+		df.ID, df.Event, df.Field, df.Type, df.SampleValue, df.Count, df.FirstSeenAt, df.LastSeenAt)
+}
+
+// DbpDiscoveredEvent 未定义事件发现记录（上报的事件未在 dbp_events 中定义时记录），审核后可一键转为事件定义
+type DbpDiscoveredEvent struct {
+	gorm.Model
+	ID    uint
+	Event string `gorm:"size:255;uniqueIndex:uidx_dbp_discovered_events_event"`
+	// 上报过该事件的 sdk 类型，逗号分隔
+	Libs string
+	// 最近上报的原始数据示例（json 数组），最多 MaxSamplePayloads 条
+	SamplePayloads string
+	// 累计上报次数，首次、最近一次上报时间
+	Count       int64
+	FirstSeenAt time.Time
+	LastSeenAt  time.Time
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// MaxSamplePayloads 未定义事件保留的原始数据示例条数
+const MaxSamplePayloads = 3
+
+func (de DbpDiscoveredEvent) String() string {
+	return fmt.Sprintf("{ID: %d, Event: %s, Libs: %s, Count: %d, FirstSeenAt: %s, LastSeenAt: %s}",
+		de.ID, de.Event, de.Libs, de.Count, de.FirstSeenAt, de.LastSeenAt)
+}
+
+// MergeLibs 合并逗号分隔的 sdk 类型，去重并保持首次出现的顺序
+func MergeLibs(libs string, others ...string) string {
+	var merged []string
+	seen := make(map[string]bool)
+	for _, value := range append([]string{libs}, others...) {
+		for _, lib := range strings.Split(value, ",") {
+			if lib != "" && !seen[lib] {
+				seen[lib] = true
+				merged = append(merged, lib)
+			}
+		}
+	}
+	return strings.Join(merged, ",")
+}
+
+// MergeSamplePayloads 合并原始数据示例（json 数组），保留最近的 MaxSamplePayloads 条
+func MergeSamplePayloads(samples string, newer []string) string {
+	var merged []string
+	if samples != "" {
+		_ = json.Unmarshal([]byte(samples), &merged)
+	}
+	merged = append(merged, newer...)
+	if len(merged) > MaxSamplePayloads {
+		merged = merged[len(merged)-MaxSamplePayloads:]
+	}
+	bytes, _ := json.Marshal(merged)
+	return string(bytes)
+}
+
 // ----------------------- Database access functions -------------------------
 var _db *gorm.DB
 
@@ -104,40 +265,401 @@ func InitDb(config *configer.Config) {
 	if _db.Migrator().HasTable(&DbpFieldEnumValue{}) == false {
 		_db.Migrator().CreateTable(&DbpFieldEnumValue{})
 	}
+	if _db.Migrator().HasTable(&DbpProfileField{}) == false {
+		_db.Migrator().CreateTable(&DbpProfileField{})
+	}
+	if _db.Migrator().HasTable(&DbpItemField{}) == false {
+		_db.Migrator().CreateTable(&DbpItemField{})
+	}
+	if _db.Migrator().HasTable(&DbpDiscoveredField{}) == false {
+		_db.Migrator().CreateTable(&DbpDiscoveredField{})
+	}
+	if _db.Migrator().HasTable(&DbpDiscoveredEvent{}) == false {
+		_db.Migrator().CreateTable(&DbpDiscoveredEvent{})
+	}
+}
+
+// Close 关闭数据库连接池
+func Close() error {
+	if _db == nil {
+		return nil
+	}
+	sqlDB, err := _db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
 }
 
 // FindAllEvents find all events
 // return the pointer of []DbpEvent
-func FindAllEvents() *[]DbpEvent {
+func FindAllEvents() (*[]DbpEvent, error) {
 	var events []DbpEvent
 	result := _db.Find(&events)
 	if result.Error != nil {
-		panic(result.Error)
+		return nil, result.Error
 	}
 	logger.Logger.Info("find " + strconv.Itoa(int(result.RowsAffected)) + " rows")
-	return &events
+	return &events, nil
 }
 
 // FindAllFields find all fields
 // return the pointer of []DbpFields
-func FindAllFields() *[]DbpField {
+func FindAllFields() (*[]DbpField, error) {
 	var fields []DbpField
-	_db.Find(&fields)
-	return &fields
+	if err := _db.Find(&fields).Error; err != nil {
+		return nil, err
+	}
+	return &fields, nil
+}
+
+// FindAllEventFields find all EventFields
+// return the pointer of []DbpEventField
+func FindAllEventFields() (*[]DbpEventField, error) {
+	var eventFields []DbpEventField
+	if err := _db.Find(&eventFields).Error; err != nil {
+		return nil, err
+	}
+	return &eventFields, nil
 }
 
 // FindAllEventFieldByEvent find all EventFields by Event
 // return the pointer of []DbpEventField
-func FindAllEventFieldByEvent(event string) *[]DbpEventField {
+func FindAllEventFieldByEvent(event string) (*[]DbpEventField, error) {
 	var eventFields []DbpEventField
-	_db.Where("event = ?", event).Find(&eventFields)
-	return &eventFields
+	if err := _db.Where("event = ?", event).Find(&eventFields).Error; err != nil {
+		return nil, err
+	}
+	return &eventFields, nil
+}
+
+// FindAllEnumValues find all EnumValues
+// return the pointer of []DbpFieldEnumValue
+func FindAllEnumValues() (*[]DbpFieldEnumValue, error) {
+	var enumValues []DbpFieldEnumValue
+	if err := _db.Find(&enumValues).Error; err != nil {
+		return nil, err
+	}
+	return &enumValues, nil
 }
 
 // FindAllEnumValuesByField find all EnumValues by field
 // return the pointer of []DbpFieldEnumValue
-func FindAllEnumValuesByField(field string) *[]DbpFieldEnumValue {
+func FindAllEnumValuesByField(field string) (*[]DbpFieldEnumValue, error) {
 	var enumValues []DbpFieldEnumValue
-	_db.Where("field = ?", field).Find(&enumValues)
-	return &enumValues
+	if err := _db.Where("field = ?", field).Find(&enumValues).Error; err != nil {
+		return nil, err
+	}
+	return &enumValues, nil
+}
+
+// FindAllProfileFields find all profile fields
+// return the pointer of []DbpProfileField
+func FindAllProfileFields() (*[]DbpProfileField, error) {
+	var profileFields []DbpProfileField
+	if err := _db.Find(&profileFields).Error; err != nil {
+		return nil, err
+	}
+	return &profileFields, nil
+}
+
+// FindAllItemFields find all item fields
+// return the pointer of []DbpItemField
+func FindAllItemFields() (*[]DbpItemField, error) {
+	var itemFields []DbpItemField
+	if err := _db.Find(&itemFields).Error; err != nil {
+		return nil, err
+	}
+	return &itemFields, nil
+}
+
+// ----------------------- Metadata management -------------------------
+// 元数据管理接口使用，写操作返回 error 由调用方处理；删除均为软删除（deleted_at）
+
+// ErrRecordNotFound 记录不存在
+var ErrRecordNotFound = gorm.ErrRecordNotFound
+
+// FindEventById find event by id
+func FindEventById(id uint) (*DbpEvent, error) {
+	var event DbpEvent
+	if err := _db.First(&event, id).Error; err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+// FindEventByName find event by event name
+func FindEventByName(name string) (*DbpEvent, error) {
+	var event DbpEvent
+	if err := _db.Where("event = ?", name).First(&event).Error; err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+// SaveEvent create or update event
+func SaveEvent(event *DbpEvent) error {
+	return _db.Save(event).Error
+}
+
+// DeleteEvent 删除事件及其事件字段配置
+func DeleteEvent(event *DbpEvent) error {
+	return _db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("event = ?", event.Event).Delete(&DbpEventField{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(event).Error
+	})
+}
+
+// FindFieldById find field by id
+func FindFieldById(id uint) (*DbpField, error) {
+	var field DbpField
+	if err := _db.First(&field, id).Error; err != nil {
+		return nil, err
+	}
+	return &field, nil
+}
+
+// FindFieldByName find field by field name
+func FindFieldByName(name string) (*DbpField, error) {
+	var field DbpField
+	if err := _db.Where("field = ?", name).First(&field).Error; err != nil {
+		return nil, err
+	}
+	return &field, nil
+}
+
+// SaveField create or update field
+func SaveField(field *DbpField) error {
+	return _db.Save(field).Error
+}
+
+// DeleteField 删除字段及引用该字段的事件字段配置、枚举值，返回引用该字段的事件
+func DeleteField(field *DbpField) ([]string, error) {
+	var events []string
+	err := _db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&DbpEventField{}).Where("field = ?", field.Field).Distinct().Pluck("event", &events).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("field = ?", field.Field).Delete(&DbpEventField{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("field = ?", field.Field).Delete(&DbpFieldEnumValue{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(field).Error
+	})
+	return events, err
+}
+
+// FindEventFieldById find event field by id
+func FindEventFieldById(id uint) (*DbpEventField, error) {
+	var eventField DbpEventField
+	if err := _db.First(&eventField, id).Error; err != nil {
+		return nil, err
+	}
+	return &eventField, nil
+}
+
+// FindEventField find event field by event and field
+func FindEventField(event string, field string) (*DbpEventField, error) {
+	var eventField DbpEventField
+	if err := _db.Where("event = ? AND field = ?", event, field).First(&eventField).Error; err != nil {
+		return nil, err
+	}
+	return &eventField, nil
+}
+
+// SaveEventField create or update event field
+func SaveEventField(eventField *DbpEventField) error {
+	return _db.Save(eventField).Error
+}
+
+// DeleteEventField soft delete event field
+func DeleteEventField(eventField *DbpEventField) error {
+	return _db.Delete(eventField).Error
+}
+
+// FindEnumValueById find enum value by id
+func FindEnumValueById(id uint) (*DbpFieldEnumValue, error) {
+	var enumValue DbpFieldEnumValue
+	if err := _db.First(&enumValue, id).Error; err != nil {
+		return nil, err
+	}
+	return &enumValue, nil
+}
+
+// FindEnumValue find enum value by field and value
+func FindEnumValue(field string, value string) (*DbpFieldEnumValue, error) {
+	var enumValue DbpFieldEnumValue
+	if err := _db.Where("field = ? AND enum_value = ?", field, value).First(&enumValue).Error; err != nil {
+		return nil, err
+	}
+	return &enumValue, nil
+}
+
+// FindDeletedEnumValue find soft deleted enum value by field and value
+func FindDeletedEnumValue(field string, value string) (*DbpFieldEnumValue, error) {
+	var enumValue DbpFieldEnumValue
+	if err := _db.Unscoped().Where("field = ? AND enum_value = ? AND deleted_at IS NOT NULL", field, value).First(&enumValue).Error; err != nil {
+		return nil, err
+	}
+	return &enumValue, nil
+}
+
+// FindDeletedEnumValues find all soft deleted enum values
+// return the pointer of []DbpFieldEnumValue
+func FindDeletedEnumValues() (*[]DbpFieldEnumValue, error) {
+	var enumValues []DbpFieldEnumValue
+	if err := _db.Unscoped().Where("deleted_at IS NOT NULL").Find(&enumValues).Error; err != nil {
+		return nil, err
+	}
+	return &enumValues, nil
+}
+
+// RestoreEnumValue 恢复软删除的枚举值
+// 唯一索引 uidx_field_value(field, enum_value) 不包含 deleted_at，删除后重新新增相同的枚举值时需要恢复原记录
+func RestoreEnumValue(enumValue *DbpFieldEnumValue) error {
+	enumValue.DeletedAt = gorm.DeletedAt{}
+	return _db.Unscoped().Save(enumValue).Error
+}
+
+// SaveEnumValue create or update enum value
+func SaveEnumValue(enumValue *DbpFieldEnumValue) error {
+	return _db.Save(enumValue).Error
+}
+
+// DeleteEnumValue soft delete enum value
+func DeleteEnumValue(enumValue *DbpFieldEnumValue) error {
+	return _db.Delete(enumValue).Error
+}
+
+// FindAllDiscoveredFields find all discovered fields, filter by event when event is not empty
+// return the pointer of []DbpDiscoveredField
+func FindAllDiscoveredFields(event string) (*[]DbpDiscoveredField, error) {
+	var discoveredFields []DbpDiscoveredField
+	db := _db.Order("event, field")
+	if event != "" {
+		db = db.Where("event = ?", event)
+	}
+	if err := db.Find(&discoveredFields).Error; err != nil {
+		return nil, err
+	}
+	return &discoveredFields, nil
+}
+
+// FindDiscoveredFieldById find discovered field by id
+func FindDiscoveredFieldById(id uint) (*DbpDiscoveredField, error) {
+	var discoveredField DbpDiscoveredField
+	if err := _db.First(&discoveredField, id).Error; err != nil {
+		return nil, err
+	}
+	return &discoveredField, nil
+}
+
+// MergeDiscoveredField 合并一段时间内的发现记录：记录不存在时新增，存在时累加次数，更新最近上报时间、类型和示例值
+// 多个实例同时写入同一属性时依赖唯一索引 uidx_dbp_discovered_fields_event_field 原子更新（INSERT ... ON DUPLICATE KEY UPDATE）
+func MergeDiscoveredField(discovered *DbpDiscoveredField) error {
+	return _db.Clauses(clause.OnConflict{
+		DoUpdates: append(clause.AssignmentColumns([]string{"last_seen_at", "type", "sample_value", "updated_at"}),
+			clause.Assignment{Column: clause.Column{Name: "count"}, Value: gorm.Expr("`count` + VALUES(`count`)")}),
+	}).Create(discovered).Error
+}
+
+// DeleteDiscoveredField delete discovered field (reviewed)
+// 不是元数据，直接删除，之后再次上报时重新记录
+func DeleteDiscoveredField(discoveredField *DbpDiscoveredField) error {
+	return _db.Unscoped().Delete(discoveredField).Error
+}
+
+// FindAllDiscoveredEvents find all discovered events, the most reported first
+// return the pointer of []DbpDiscoveredEvent
+func FindAllDiscoveredEvents() (*[]DbpDiscoveredEvent, error) {
+	var discoveredEvents []DbpDiscoveredEvent
+	if err := _db.Order("count desc").Find(&discoveredEvents).Error; err != nil {
+		return nil, err
+	}
+	return &discoveredEvents, nil
+}
+
+// FindDiscoveredEventById find discovered event by id
+func FindDiscoveredEventById(id uint) (*DbpDiscoveredEvent, error) {
+	var discoveredEvent DbpDiscoveredEvent
+	if err := _db.First(&discoveredEvent, id).Error; err != nil {
+		return nil, err
+	}
+	return &discoveredEvent, nil
+}
+
+// MergeDiscoveredEvent 合并一段时间内的发现记录：记录不存在时新增，存在时累加次数，合并 sdk 类型和原始数据示例
+// samples 为这段时间内的原始数据示例。
+// 多个实例同时写入同一事件时，依赖唯一索引 uidx_dbp_discovered_events_event 先插入空记录（已存在时忽略），
+// 再加锁（SELECT ... FOR UPDATE）读取后合并，不会重复插入或丢失计数；
+// 事件已定义（如已转为事件定义，其他实例的快照未刷新前记录的次数）时删除发现记录，不再记录
+func MergeDiscoveredEvent(discovered *DbpDiscoveredEvent, samples []string) error {
+	return _db.Transaction(func(tx *gorm.DB) error {
+		empty := &DbpDiscoveredEvent{Event: discovered.Event, FirstSeenAt: discovered.FirstSeenAt, LastSeenAt: discovered.LastSeenAt}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(empty).Error; err != nil {
+			return err
+		}
+		var existing DbpDiscoveredEvent
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("event = ?", discovered.Event).First(&existing).Error; err != nil {
+			return err
+		}
+
+		var defined int64
+		if err := tx.Model(&DbpEvent{}).Where("event = ?", discovered.Event).Count(&defined).Error; err != nil {
+			return err
+		}
+		if defined > 0 {
+			return tx.Unscoped().Delete(&existing).Error
+		}
+
+		existing.Count += discovered.Count
+		existing.LastSeenAt = discovered.LastSeenAt
+		existing.Libs = MergeLibs(existing.Libs, discovered.Libs)
+		existing.SamplePayloads = MergeSamplePayloads(existing.SamplePayloads, samples)
+		return tx.Save(&existing).Error
+	})
+}
+
+// DeleteDiscoveredEvent delete discovered event (reviewed)
+// 不是元数据，直接删除，之后再次上报时重新记录
+func DeleteDiscoveredEvent(discoveredEvent *DbpDiscoveredEvent) error {
+	return _db.Unscoped().Delete(discoveredEvent).Error
+}
+
+// PromoteDiscoveredEvent 在一个事务中新增事件定义并删除发现记录
+func PromoteDiscoveredEvent(discoveredEvent *DbpDiscoveredEvent, event *DbpEvent) error {
+	return _db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(event).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(discoveredEvent).Error
+	})
+}
+
+// MetadataChanges 元数据变更，导入 schema bundle 时在一个事务中应用
+type MetadataChanges struct {
+	Save   []interface{} // 新增、修改、恢复（软删除后重新新增）的记录（*DbpXxx），修改、恢复的记录需带 ID
+	Delete []interface{} // 删除的记录（*DbpXxx），软删除
+}
+
+// ApplyMetadataChanges 在一个事务中应用元数据变更，任意一条失败全部回滚
+func ApplyMetadataChanges(changes *MetadataChanges) error {
+	return _db.Transaction(func(tx *gorm.DB) error {
+		for _, record := range changes.Delete {
+			if err := tx.Delete(record).Error; err != nil {
+				return err
+			}
+		}
+		// 不带软删除条件保存，恢复的软删除记录（deleted_at 已清空）才能更新
+		for _, record := range changes.Save {
+			if err := tx.Unscoped().Save(record).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 }
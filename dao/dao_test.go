@@ -21,3 +21,13 @@ func TestJson(t *testing.T) {
 		print("error: ", err.Error())
 	}
 }
+
+func TestMergeDiscoveredEvent(t *testing.T) {
+	if libs := MergeLibs("js,iOS", "iOS", "Android"); libs != "js,iOS,Android" {
+		t.Errorf("unexpected libs %s", libs)
+	}
+	samples := MergeSamplePayloads(`["1","2"]`, []string{"3", "4"})
+	if samples != `["2","3","4"]` {
+		t.Errorf("unexpected samples %s", samples)
+	}
+}
@@ -0,0 +1,213 @@
+package discovery
+
+import (
+	"encoding/json"
+	"liangck.xyz/data-service/sensors-log-acceptor/cache"
+	"liangck.xyz/data-service/sensors-log-acceptor/configer"
+	"liangck.xyz/data-service/sensors-log-acceptor/dao"
+	"liangck.xyz/data-service/sensors-log-acceptor/logger"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// -------------------- Discovery. 元数据发现
+// 校验时发现的未定义属性、未定义事件先在进程内按（事件, 属性）、事件聚合，定时合并写入数据库
+// （dbp_discovered_fields、dbp_discovered_events），避免每条数据访问数据库；多个实例各自聚合，合并时累加次数。
+// 只在元数据来源为数据库时开启。每个间隔内聚合的记录数超过 maxEntries 时丢弃新的记录，
+// 防止异常上报（如属性名中带随机值）占满内存。
+//------------------------
+
+const defaultFlushInterval = 60 * time.Second
+const defaultMaxEntries = 10000
+
+// 示例值、原始数据示例最大长度（字符数）
+const maxSampleLength = 255
+const maxPayloadLength = 2048
+
+var conf *Conf
+
+var mu sync.Mutex
+var fields = make(map[fieldKey]*dao.DbpDiscoveredField)
+var events = make(map[string]*eventStat)
+var dropped int64
+
+var stop chan struct{}
+var stopped chan struct{}
+
+// Conf discovery configuration
+type Conf struct {
+	Enable        bool
+	FlushInterval time.Duration // 写入数据库的间隔
+	MaxEntries    int           // 每个间隔内最多聚合的记录数
+}
+
+type fieldKey struct {
+	event string
+	field string
+}
+
+// 未定义事件的聚合记录，示例只保留最近的 dao.MaxSamplePayloads 条
+type eventStat struct {
+	discovered *dao.DbpDiscoveredEvent
+	samples    []string
+}
+
+// Init 初始化并启动定时写入任务，元数据来源为文件时不开启
+func Init(config *configer.Config) {
+	conf = &Conf{
+		Enable:        config.MetadataSource != cache.SourceFile,
+		FlushInterval: time.Duration(config.DiscoveryFlushInterval) * time.Second,
+		MaxEntries:    config.DiscoveryMaxEntries,
+	}
+	if conf.FlushInterval <= 0 {
+		conf.FlushInterval = defaultFlushInterval
+	}
+	if conf.MaxEntries <= 0 {
+		conf.MaxEntries = defaultMaxEntries
+	}
+	if !conf.Enable {
+		return
+	}
+	stop = make(chan struct{})
+	stopped = make(chan struct{})
+	go run(conf.FlushInterval)
+}
+
+// Enabled 是否开启发现记录
+func Enabled() bool {
+	return conf != nil && conf.Enable
+}
+
+// RecordField 记录事件上报的未定义属性，fieldType 为推断的字段类型，sample 为上报值
+func RecordField(event string, field string, fieldType string, sample interface{}) {
+	if !Enabled() {
+		return
+	}
+	now := time.Now()
+	sampleValue := marshalSample(sample)
+	key := fieldKey{event: event, field: field}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if discovered, ok := fields[key]; ok {
+		discovered.Count++
+		discovered.LastSeenAt = now
+		discovered.Type = fieldType
+		discovered.SampleValue = sampleValue
+		return
+	}
+	if full() {
+		dropped++
+		return
+	}
+	fields[key] = &dao.DbpDiscoveredField{Event: event, Field: field, Type: fieldType, SampleValue: sampleValue,
+		Count: 1, FirstSeenAt: now, LastSeenAt: now}
+}
+
+// RecordEvent 记录上报的未定义事件，lib 为 sdk 类型，payload 为原始数据
+func RecordEvent(event string, lib string, payload []byte) {
+	if !Enabled() {
+		return
+	}
+	now := time.Now()
+	sample := truncate(string(payload), maxPayloadLength)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if stat, ok := events[event]; ok {
+		stat.discovered.Count++
+		stat.discovered.LastSeenAt = now
+		stat.discovered.Libs = dao.MergeLibs(stat.discovered.Libs, lib)
+		stat.samples = append(stat.samples, sample)
+		if len(stat.samples) > dao.MaxSamplePayloads {
+			stat.samples = stat.samples[1:]
+		}
+		return
+	}
+	if full() {
+		dropped++
+		return
+	}
+	events[event] = &eventStat{
+		discovered: &dao.DbpDiscoveredEvent{Event: event, Libs: lib, Count: 1, FirstSeenAt: now, LastSeenAt: now},
+		samples:    []string{sample},
+	}
+}
+
+// full 聚合的记录数是否已达到上限，调用方需持有锁
+func full() bool {
+	return len(fields)+len(events) >= conf.MaxEntries
+}
+
+// marshalSample 示例值序列化为 json，超长时截断
+func marshalSample(sample interface{}) string {
+	bytes, err := json.Marshal(sample)
+	if err != nil {
+		return ""
+	}
+	return truncate(string(bytes), maxSampleLength)
+}
+
+func truncate(value string, length int) string {
+	if utf8.RuneCountInString(value) > length {
+		return string([]rune(value)[:length])
+	}
+	return value
+}
+
+func run(interval time.Duration) {
+	defer close(stopped)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			flush()
+		case <-stop:
+			flush()
+			return
+		}
+	}
+}
+
+// flush 聚合的记录合并写入数据库，写入失败的记录丢弃
+func flush() {
+	mu.Lock()
+	pendingFields, pendingEvents := fields, events
+	droppedCount := dropped
+	fields = make(map[fieldKey]*dao.DbpDiscoveredField)
+	events = make(map[string]*eventStat)
+	dropped = 0
+	mu.Unlock()
+
+	if droppedCount > 0 {
+		logger.Logger.Warn("discovery entries exceed " + strconv.Itoa(conf.MaxEntries) + ", dropped " + strconv.FormatInt(droppedCount, 10) + " records")
+	}
+	for _, discovered := range pendingFields {
+		if err := dao.MergeDiscoveredField(discovered); err != nil {
+			logger.Logger.Error("failed to save discovered field " + discovered.Event + "." + discovered.Field + ". caused by: " + err.Error())
+		}
+	}
+	snapshot := cache.Snapshot()
+	for _, stat := range pendingEvents {
+		// 记录后事件已定义（如已转为事件定义），不再记录
+		if snapshot.EventExists(stat.discovered.Event) {
+			continue
+		}
+		if err := dao.MergeDiscoveredEvent(stat.discovered, stat.samples); err != nil {
+			logger.Logger.Error("failed to save discovered event " + stat.discovered.Event + ". caused by: " + err.Error())
+		}
+	}
+}
+
+// Close 停止定时任务，写入剩余的记录
+func Close() {
+	if stop == nil {
+		return
+	}
+	close(stop)
+	<-stopped
+	stop = nil
+}
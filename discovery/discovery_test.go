@@ -0,0 +1,46 @@
+package discovery
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRecordField(t *testing.T) {
+	conf = &Conf{Enable: true, MaxEntries: 2}
+	defer func() { conf = nil }()
+
+	RecordField("view", "color", "string", "red")
+	RecordField("view", "color", "string", "blue")
+	RecordField("view", "size", "long", 3)
+	RecordField("view", "weight", "float", 1.5)
+
+	color := fields[fieldKey{event: "view", field: "color"}]
+	if color == nil || color.Count != 2 || color.SampleValue != `"blue"` {
+		t.Fatalf("unexpected color record: %+v", color)
+	}
+	if len(fields) != 2 || dropped != 1 {
+		t.Fatalf("expected 2 records and 1 dropped, got %d, %d", len(fields), dropped)
+	}
+
+	if sample := marshalSample(strings.Repeat("a", 300)); len(sample) != maxSampleLength {
+		t.Fatalf("expected sample truncated to %d, got %d", maxSampleLength, len(sample))
+	}
+}
+
+func TestRecordEvent(t *testing.T) {
+	conf = &Conf{Enable: true, MaxEntries: 10}
+	defer func() { conf = nil }()
+
+	for i := 0; i < 5; i++ {
+		RecordEvent("unknown_view", "js", []byte(`{"event":"unknown_view","seq":`+string(rune('0'+i))+`}`))
+	}
+	RecordEvent("unknown_view", "iOS", []byte(`{}`))
+
+	stat := events["unknown_view"]
+	if stat == nil || stat.discovered.Count != 6 || stat.discovered.Libs != "js,iOS" {
+		t.Fatalf("unexpected event record: %+v", stat)
+	}
+	if len(stat.samples) != 3 || stat.samples[2] != `{}` {
+		t.Fatalf("expected latest 3 samples, got %v", stat.samples)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"github.com/Jeffail/gabs"
+	"liangck.xyz/data-service/sensors-log-acceptor/cache"
+	"liangck.xyz/data-service/sensors-log-acceptor/dao"
+	. "liangck.xyz/data-service/sensors-log-acceptor/model"
+	"math"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// 扩展字段类型
+// datetime：时间，字符串按配置的格式（validation.datetimeLayouts）解析，数值视为毫秒时间戳，统一输出毫秒时间戳（long）
+// date：日期，字符串按配置的格式（validation.dateLayouts）解析，统一输出 2006-01-02 格式
+// number：通用数值，整数输出 long，小数输出 float
+// list：数组，元素类型为 ElementType（默认 string），元素个数不超过 MaxElements（0 为不限制），string 元素长度不超过 Length
+// object：嵌套对象，按子字段（Parent 为该字段的字段定义，JsonPath 为相对对象的路径）校验，只输出定义了的子字段
+
+const TypeDatetime = "datetime"
+const TypeDate = "date"
+const TypeNumber = "number"
+const TypeList = "list"
+const TypeObject = "object"
+
+const DateLayout = "2006-01-02"
+
+var defaultDatetimeLayouts = []string{"2006-01-02 15:04:05", time.RFC3339}
+var defaultDateLayouts = []string{DateLayout}
+
+// list 元素支持的类型
+var elementTypes = map[string]bool{
+	TypeString: true,
+	TypeInt:    true,
+	TypeLong:   true,
+	TypeFloat:  true,
+	TypeNumber: true,
+	TypeBool:   true,
+}
+
+// validDatetimeField 校验时间字段，转换为毫秒时间戳
+func validDatetimeField(fieldValue interface{}, field dao.DbpField, data *map[string]interface{}) *ValidResult {
+	switch value := fieldValue.(type) {
+	case float64:
+		(*data)[field.Field] = int64(value)
+		return &ValidResult{OK: true, ErrType: None}
+	case string:
+		if t, ok := parseTime(value, handlerConf.DatetimeLayouts); ok {
+			(*data)[field.Field] = t.UnixNano() / int64(time.Millisecond)
+			return &ValidResult{OK: true, ErrType: None}
+		}
+		return &ValidResult{OK: false, Err: "field: " + field.Field + " invalid datetime [" + value + "]", ErrType: InvalidFormat}
+	}
+	return typeMismatch(fieldValue, field)
+}
+
+// validDateField 校验日期字段，转换为 2006-01-02 格式
+func validDateField(fieldValue interface{}, field dao.DbpField, data *map[string]interface{}) *ValidResult {
+	value, ok := fieldValue.(string)
+	if !ok {
+		return typeMismatch(fieldValue, field)
+	}
+	t, ok := parseTime(value, handlerConf.DateLayouts)
+	if !ok {
+		return &ValidResult{OK: false, Err: "field: " + field.Field + " invalid date [" + value + "]", ErrType: InvalidFormat}
+	}
+	(*data)[field.Field] = t.Format(DateLayout)
+	return &ValidResult{OK: true, ErrType: None}
+}
+
+// parseTime 按顺序尝试各个格式解析时间，不带时区的时间按本地时区解析
+func parseTime(value string, layouts []string) (time.Time, bool) {
+	for _, layout := range layouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// validNumberField 校验通用数值字段
+func validNumberField(fieldValue interface{}, field dao.DbpField, data *map[string]interface{}) *ValidResult {
+	value, ok := fieldValue.(float64)
+	if !ok {
+		return typeMismatch(fieldValue, field)
+	}
+	(*data)[field.Field] = normalizeNumber(value)
+	return &ValidResult{OK: true, ErrType: None}
+}
+
+// normalizeNumber 整数（在 long 范围内）转换为 int64，其他保持 float64
+func normalizeNumber(value float64) interface{} {
+	if value == math.Trunc(value) && value >= math.MinInt64 && value < math.MaxInt64 {
+		return int64(value)
+	}
+	return value
+}
+
+// validListField 校验数组字段，逐个校验并转换元素
+func validListField(fieldValue interface{}, field dao.DbpField, data *map[string]interface{}) *ValidResult {
+	values, ok := fieldValue.([]interface{})
+	if !ok {
+		return typeMismatch(fieldValue, field)
+	}
+	if field.MaxElements > 0 && len(values) > field.MaxElements {
+		return &ValidResult{OK: false, Err: "field: " + field.Field + " elements count large than " + strconv.Itoa(field.MaxElements), ErrType: ValueTooLong}
+	}
+
+	elementType := field.ElementType
+	if elementType == "" {
+		elementType = TypeString
+	}
+	list := make([]interface{}, 0, len(values))
+	for idx, value := range values {
+		element, ok := convertElement(value, elementType)
+		if !ok {
+			return &ValidResult{OK: false, Err: "Field: [" + field.Field + "] element " + strconv.Itoa(idx) + " type mismatch. dest type is " + elementType, ErrType: TypeMisMatch}
+		}
+		if str, isString := element.(string); isString && utf8.RuneCountInString(str) > field.Length {
+			return &ValidResult{OK: false, Err: "field: " + field.Field + " element " + strconv.Itoa(idx) + " length large than " + strconv.Itoa(field.Length), ErrType: ValueTooLong}
+		}
+		list = append(list, element)
+	}
+	(*data)[field.Field] = list
+	return &ValidResult{OK: true, ErrType: None}
+}
+
+// convertElement 转换 list 元素为指定类型，类型不匹配返回 false；int、long 元素有小数部分时视为类型不匹配，不截断
+func convertElement(value interface{}, elementType string) (interface{}, bool) {
+	switch elementType {
+	case TypeString:
+		str, ok := value.(string)
+		return str, ok
+	case TypeBool:
+		b, ok := value.(bool)
+		return b, ok
+	}
+	number, ok := value.(float64)
+	if !ok {
+		return nil, false
+	}
+	switch elementType {
+	case TypeInt, TypeLong:
+		if number != math.Trunc(number) {
+			return nil, false
+		}
+		if elementType == TypeInt {
+			return int(number), true
+		}
+		return int64(number), true
+	case TypeNumber:
+		return normalizeNumber(number), true
+	}
+	return number, true
+}
+
+// validObjectField 按子字段定义校验嵌套对象，输出只包含子字段的对象
+func validObjectField(snapshot *cache.MetadataSnapshot, fieldValue interface{}, field dao.DbpField, data *map[string]interface{}) *ValidResult {
+	if _, ok := fieldValue.(map[string]interface{}); !ok {
+		return typeMismatch(fieldValue, field)
+	}
+	container, err := gabs.Consume(fieldValue)
+	if err != nil {
+		return &ValidResult{OK: false, Err: "field: " + field.Field + " invalid object", ErrType: InvalidFormat}
+	}
+	object := make(map[string]interface{}, len(field.Children))
+	for _, child := range field.Children {
+		if validResult := validField(snapshot, container, &object, child); !validResult.OK {
+			validResult.Err = "field: " + field.Field + " " + validResult.Err
+			return validResult
+		}
+	}
+	(*data)[field.Field] = object
+	return &ValidResult{OK: true, ErrType: None}
+}
+
+func typeMismatch(fieldValue interface{}, field dao.DbpField) *ValidResult {
+	return &ValidResult{OK: false, Err: "Field: [" + field.Field + "] type mismatch. json value type is " + jsonTypeName(fieldValue) + " and dest type is " + field.Type, ErrType: TypeMisMatch}
+}
+
+// jsonTypeName json 值的类型名称，用于错误信息
+func jsonTypeName(value interface{}) string {
+	switch value.(type) {
+	case string:
+		return "string"
+	case float64:
+		return "float64"
+	case bool:
+		return "bool"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return "unknown"
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"github.com/Jeffail/gabs"
+	"liangck.xyz/data-service/sensors-log-acceptor/cache"
+	"liangck.xyz/data-service/sensors-log-acceptor/dao"
+	. "liangck.xyz/data-service/sensors-log-acceptor/model"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestValidExtendedTypes(t *testing.T) {
+	handlerConf = &HandlerConf{DatetimeLayouts: defaultDatetimeLayouts, DateLayouts: defaultDateLayouts}
+	snapshot := cache.NewMetadataSnapshot(&cache.Metadata{}, nil)
+	jsonParsed, _ := gabs.ParseJSON([]byte(`{"properties":{
+		"login_time":"2021-06-01 08:00:00","$time":1622505600000,"birthday":"2021-06-01","price":12,"ratio":0.5,
+		"tags":["a","b"],"scores":[1,2],"ratios":[1,1.7],"address":{"city":"shanghai","zip":200000,"extra":true}}}`))
+
+	loginTime, _ := time.ParseInLocation("2006-01-02 15:04:05", "2021-06-01 08:00:00", time.Local)
+	cases := []struct {
+		field    dao.DbpField
+		expected interface{}
+	}{
+		{dao.DbpField{Field: "login_time", JsonPath: "properties.login_time", Type: TypeDatetime}, loginTime.UnixNano() / int64(time.Millisecond)},
+		{dao.DbpField{Field: "time", JsonPath: "properties.$time", Type: TypeDatetime}, int64(1622505600000)},
+		{dao.DbpField{Field: "birthday", JsonPath: "properties.birthday", Type: TypeDate}, "2021-06-01"},
+		{dao.DbpField{Field: "price", JsonPath: "properties.price", Type: TypeNumber}, int64(12)},
+		{dao.DbpField{Field: "ratio", JsonPath: "properties.ratio", Type: TypeNumber}, 0.5},
+		{dao.DbpField{Field: "tags", JsonPath: "properties.tags", Type: TypeList, Length: 8, MaxElements: 2}, []interface{}{"a", "b"}},
+		{dao.DbpField{Field: "scores", JsonPath: "properties.scores", Type: TypeList, ElementType: TypeLong}, []interface{}{int64(1), int64(2)}},
+		{dao.DbpField{Field: "address", JsonPath: "properties.address", Type: TypeObject, Children: []dao.DbpField{
+			{Field: "city", JsonPath: "city", Type: TypeString, Length: 32},
+			{Field: "zip", JsonPath: "zip", Type: TypeLong, Length: 8},
+		}}, map[string]interface{}{"city": "shanghai", "zip": int64(200000)}},
+	}
+	for _, c := range cases {
+		data := map[string]interface{}{}
+		if validResult := validField(snapshot, jsonParsed, &data, c.field); !validResult.OK {
+			t.Errorf("%s should be valid, got %s", c.field.Field, validResult.Err)
+			continue
+		}
+		if !reflect.DeepEqual(data[c.field.Field], c.expected) {
+			t.Errorf("%s should be %v, got %v", c.field.Field, c.expected, data[c.field.Field])
+		}
+	}
+
+	invalid := []struct {
+		field   dao.DbpField
+		errType ErrType
+	}{
+		{dao.DbpField{Field: "birthday", JsonPath: "properties.birthday", Type: TypeDatetime}, InvalidFormat},
+		{dao.DbpField{Field: "tags", JsonPath: "properties.tags", Type: TypeList, Length: 8, MaxElements: 1}, ValueTooLong},
+		{dao.DbpField{Field: "tags", JsonPath: "properties.tags", Type: TypeList, Length: 8, ElementType: TypeInt}, TypeMisMatch},
+		// 有小数部分的 int、long 元素不截断
+		{dao.DbpField{Field: "ratios", JsonPath: "properties.ratios", Type: TypeList, ElementType: TypeInt}, TypeMisMatch},
+		{dao.DbpField{Field: "ratios", JsonPath: "properties.ratios", Type: TypeList, ElementType: TypeLong}, TypeMisMatch},
+		{dao.DbpField{Field: "address", JsonPath: "properties.address", Type: TypeObject, Children: []dao.DbpField{
+			{Field: "zip", JsonPath: "zip", Type: TypeString, Length: 8},
+		}}, TypeMisMatch},
+	}
+	for _, c := range invalid {
+		data := map[string]interface{}{}
+		if validResult := validField(snapshot, jsonParsed, &data, c.field); validResult.OK || validResult.ErrType != c.errType {
+			t.Errorf("%s(%s) should be %s, got %v", c.field.Field, c.field.Type, c.errType, validResult)
+		}
+	}
+}
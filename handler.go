@@ -7,46 +7,186 @@ import (
 	"encoding/json"
 	"errors"
 	"github.com/Jeffail/gabs"
+	"go.uber.org/zap"
 	"io/ioutil"
 	"liangck.xyz/data-service/sensors-log-acceptor/cache"
+	"liangck.xyz/data-service/sensors-log-acceptor/configer"
 	"liangck.xyz/data-service/sensors-log-acceptor/dao"
+	"liangck.xyz/data-service/sensors-log-acceptor/discovery"
 	"liangck.xyz/data-service/sensors-log-acceptor/kafka"
 	"liangck.xyz/data-service/sensors-log-acceptor/logger"
+	"liangck.xyz/data-service/sensors-log-acceptor/metrics"
 	. "liangck.xyz/data-service/sensors-log-acceptor/model"
 	"net/url"
 	"reflect"
 	"strconv"
+	"strings"
 	"time"
 	"unicode/utf8"
 )
 
 const EventJsonPath string = "event"
+const LibJsonPath string = "lib.$lib"
+const LibVersionJsonPath string = "lib.$lib_version"
+const DataTypeJsonPath string = "type"
 const Event = "event"
+const DataType = "type"
+const DistinctId = "distinct_id"
+const OriginalId = "original_id"
 const TypeEnum = "enum"
 const TypeFloat = "float"
 const TypeInt = "int"
+const TypeLong = "long"
+const TypeJson = "json"
 const TypeBool = "bool"
 const TypeString = "string"
 const ReceiveTime = "receive_time"
 
+// 数据类型（type）
+const DataTypeTrack = "track"
+const DataTypeTrackSignup = "track_signup"
+const DataTypeProfileSet = "profile_set"
+const DataTypeProfileSetOnce = "profile_set_once"
+const DataTypeProfileIncrement = "profile_increment"
+const DataTypeProfileAppend = "profile_append"
+const DataTypeProfileUnset = "profile_unset"
+const DataTypeProfileDelete = "profile_delete"
+const DataTypeItemSet = "item_set"
+const DataTypeItemDelete = "item_delete"
+
+var profileDataTypes = map[string]bool{
+	DataTypeProfileSet:       true,
+	DataTypeProfileSetOnce:   true,
+	DataTypeProfileIncrement: true,
+	DataTypeProfileAppend:    true,
+	DataTypeProfileUnset:     true,
+	DataTypeProfileDelete:    true,
+}
+
+// 字段校验模式
+const ValidationModeFailFast = "failFast"     // 遇到第一个字段校验错误即返回
+const ValidationModeCollectAll = "collectAll" // 校验所有字段，收集全部错误后统一上报
+
+var handlerConf *HandlerConf
+
+// HandlerConf 埋点数据处理配置
+type HandlerConf struct {
+	CrcMode         string   // crc 校验模式：off、flag、reject
+	ValidationMode  string   // 字段校验模式：failFast、collectAll
+	DatetimeLayouts []string // datetime 类型字段支持的时间格式
+	DateLayouts     []string // date 类型字段支持的日期格式
+	Policy          string   // 字段校验失败时的默认处理策略
+	// 行为事件未定义属性的默认处理方式
+	UnknownProperties string
+	// 行为事件数据是否输出数据类型字段 type
+	TrackDataType bool
+}
+
+// InitHandler 初始化埋点数据处理配置
+func InitHandler(config *configer.Config) {
+	handlerConf = &HandlerConf{
+		CrcMode:           config.CrcMode,
+		ValidationMode:    config.ValidationMode,
+		DatetimeLayouts:   config.ValidationDatetimeLayouts,
+		DateLayouts:       config.ValidationDateLayouts,
+		Policy:            config.ValidationPolicy,
+		UnknownProperties: config.ValidationUnknownProperties,
+		TrackDataType:     config.KafkaTrackDataType,
+	}
+	// 未配置时使用默认值，配置错误（如拼写错误）时同样使用默认值并输出警告
+	if !Policies[handlerConf.Policy] {
+		if handlerConf.Policy != "" {
+			logger.Logger.Warn("invalid validation.policy [" + handlerConf.Policy + "], use " + PolicyReject)
+		}
+		handlerConf.Policy = PolicyReject
+	}
+	if !UnknownPropertiesModes[handlerConf.UnknownProperties] {
+		if handlerConf.UnknownProperties != "" {
+			logger.Logger.Warn("invalid validation.unknownProperties [" + handlerConf.UnknownProperties + "], use " + UnknownPropertiesIgnore)
+		}
+		handlerConf.UnknownProperties = UnknownPropertiesIgnore
+	}
+	if len(handlerConf.DatetimeLayouts) == 0 {
+		handlerConf.DatetimeLayouts = defaultDatetimeLayouts
+	}
+	if len(handlerConf.DateLayouts) == 0 {
+		handlerConf.DateLayouts = defaultDateLayouts
+	}
+}
+
+// 消息 key 可以使用的内置字段（不在字段元数据中定义）
+var builtinMessageKeyFields = map[string]bool{Event: true, DistinctId: true, OriginalId: true, UserKey: true, ReceiveTime: true}
+
+// checkMessageKeys 启动时检查 kafka 消息 key 配置，字段未定义时消息随机分区，只输出警告
+// 按事件覆盖的配置按小写事件名匹配（配置文件的 key 会被转为小写），未匹配到事件或匹配到多个只有大小写不同的事件时同样输出警告
+func checkMessageKeys(snapshot *cache.MetadataSnapshot) {
+	defaultKey, eventKeys := kafka.MessageKeyFields()
+	if !builtinMessageKeyFields[defaultKey] && !containsField(snapshot.Fields(), defaultKey) {
+		logger.Logger.Warn("kafka message key field [" + defaultKey + "] is not defined, messages without it will be randomly partitioned")
+	}
+
+	eventsByLower := make(map[string][]string)
+	for _, event := range snapshot.Events() {
+		lowered := strings.ToLower(event)
+		eventsByLower[lowered] = append(eventsByLower[lowered], event)
+	}
+	for lowered, keyField := range eventKeys {
+		events := eventsByLower[lowered]
+		if len(events) == 0 {
+			logger.Logger.Warn("kafka message key is configured for undefined event [" + lowered + "], event names in config are case-folded")
+			continue
+		}
+		if len(events) > 1 {
+			logger.Logger.Warn("kafka message key of event [" + lowered + "] applies to events differing only in case: " + strings.Join(events, ", "))
+		}
+		for _, event := range events {
+			if !builtinMessageKeyFields[keyField] && !containsField(snapshot.FieldsByEvent(event), keyField) {
+				logger.Logger.Warn("kafka message key field [" + keyField + "] is not a field of event [" + event + "], messages will be randomly partitioned")
+			}
+		}
+	}
+}
+
+func containsField(fields []dao.DbpField, name string) bool {
+	for _, field := range fields {
+		if field.Field == name {
+			return true
+		}
+	}
+	return false
+}
+
 // Handle 处理埋点数据请求
+// transport 为上报方式（post、beacon、image），用于统计
 // todo: refactor
-func Handle(jsonData []byte) (bool, error) {
+func Handle(jsonData []byte, transport string) (bool, error) {
+	lib := metrics.UnknownLib
+	defer func() {
+		metrics.RequestsTotal.WithLabelValues(transport, lib).Inc()
+	}()
+
 	log, err := parseRequestData(string(jsonData))
 	if err != nil {
 		return false, err
 	}
 
+	// crc 校验
+	if crcResult := verifyCrc(log); !crcResult.OK {
+		return false, errors.New(crcResult.Err)
+	}
+
 	// android ios 上传的是数组
 	if log.Gzip != "" {
 		decodeString, err := base64.StdEncoding.DecodeString(log.DataList)
 		if err != nil {
+			metrics.GzipDecodeFailuresTotal.Inc()
 			return false, err
 		}
 
 		decompress, err2 := GzipDecompress(decodeString)
 		var logs []interface{}
 		if err2 != nil {
+			metrics.GzipDecodeFailuresTotal.Inc()
 			return false, err2
 		}
 
@@ -55,14 +195,17 @@ func Handle(jsonData []byte) (bool, error) {
 			return false, err3
 		}
 
-		for _, logData := range logs {
+		for idx, logData := range logs {
 			marshaled, err := json.Marshal(logData)
 			if err == nil {
-				ok, err := ParseAndValidLogData(marshaled)
+				dataLib, ok, err := ParseAndValidLogData(marshaled)
+				if idx == 0 {
+					lib = dataLib
+				}
 				if !ok && err != nil {
 					logger.Logger.Error("valid field error: " + err.Error())
 				} else {
-					logger.Logger.Info("valid field successful: " + string(marshaled))
+					logger.PayloadLogger.Debug("valid field successful", zap.ByteString("data", marshaled))
 				}
 			}
 		}
@@ -73,13 +216,21 @@ func Handle(jsonData []byte) (bool, error) {
 	// js 上传的是单条数据
 	decodeString, err := base64.StdEncoding.DecodeString(log.Data)
 	if err == nil {
-		return ParseAndValidLogData(decodeString)
+		var ok bool
+		lib, ok, err = ParseAndValidLogData(decodeString)
+		return ok, err
 	}
 
 	return true, nil
 
 }
 
+// getLib 获取上报数据的 sdk 类型（js、Android、iOS 等）的指标标签值，不在已知 sdk 类型中的为 other
+func getLib(jsonParsed *gabs.Container) string {
+	lib, _ := jsonParsed.Path(LibJsonPath).Data().(string)
+	return metrics.LibLabel(lib)
+}
+
 // parse request data by construct a url. get data and ext
 func parseRequestData(requestBody string) (*Log, error) {
 	urlPrefix := "http://localhost:8080/test?"
@@ -87,11 +238,11 @@ func parseRequestData(requestBody string) (*Log, error) {
 	u, err := url.Parse(dummyUrl)
 	if err == nil {
 		data := u.Query().Get("data")
-		//ext := u.Query().Get("ext")
+		ext := u.Query().Get("ext")
 		_gzip := u.Query().Get("gzip")
 		dataList := u.Query().Get("data_list")
 		crc := u.Query().Get("crc")
-		log := &Log{Gzip: _gzip, DataList: dataList, Data: data, Crc: crc}
+		log := &Log{Gzip: _gzip, DataList: dataList, Data: data, Crc: crc, Ext: ext}
 		return log, nil
 	}
 
@@ -112,12 +263,12 @@ func GzipDecompress(in []byte) ([]byte, error) {
 
 // validEvent
 // valid event and add event to logger data
-func validEvent(jsonParsed *gabs.Container, data *map[string]interface{}) (bool, error) {
+func validEvent(snapshot *cache.MetadataSnapshot, jsonParsed *gabs.Container, data *map[string]interface{}) (bool, error) {
 	event, ok := jsonParsed.Path(EventJsonPath).Data().(string)
 	if !ok {
 		return false, errors.New("event field not found")
 	}
-	if !cache.EventExists(event) {
+	if !snapshot.EventExists(event) {
 		return false, errors.New("Unknown event :" + event + "")
 	}
 
@@ -126,9 +277,33 @@ func validEvent(jsonParsed *gabs.Container, data *map[string]interface{}) (bool,
 	return true, nil
 }
 
+// recordUndefinedEvent 记录上报的未定义事件，供审核后转为事件定义；事件名不合法（无法定义）的不记录
+func recordUndefinedEvent(jsonParsed *gabs.Container, data []byte) {
+	event, ok := jsonParsed.Path(EventJsonPath).Data().(string)
+	if !ok || !namePattern.MatchString(event) {
+		return
+	}
+	lib, _ := sdkVersion(jsonParsed)
+	discovery.RecordEvent(event, lib, data)
+}
+
 // validField
 // 验证指定字段，如果验证通过则把该字段数据放入data字典中
-func validField(jsonParsed *gabs.Container, data *map[string]interface{}, field dao.DbpField) *ValidResult {
+// 类型校验通过后，字段配置了约束时校验约束
+func validField(snapshot *cache.MetadataSnapshot, jsonParsed *gabs.Container, data *map[string]interface{}, field dao.DbpField) *ValidResult {
+	validResult := validFieldValue(snapshot, jsonParsed, data, field)
+	if !validResult.OK || field.Rules == nil {
+		return validResult
+	}
+	value, ok := (*data)[field.Field]
+	if !ok {
+		return validResult
+	}
+	return field.Rules.Check(field.Field, value)
+}
+
+// validFieldValue 字段非空、类型、长度校验，并转换为字段类型
+func validFieldValue(snapshot *cache.MetadataSnapshot, jsonParsed *gabs.Container, data *map[string]interface{}, field dao.DbpField) *ValidResult {
 	fieldValue := jsonParsed.Path(field.JsonPath).Data()
 	if fieldValue == nil {
 		// 1.非空校验
@@ -138,6 +313,7 @@ func validField(jsonParsed *gabs.Container, data *map[string]interface{}, field
 
 		return &ValidResult{OK: true, ErrType: None}
 	}
+	fieldValue = coerceField(jsonParsed, fieldValue, field)
 	fieldType := reflect.TypeOf(fieldValue)
 
 	// 验证json格式字段
@@ -145,14 +321,32 @@ func validField(jsonParsed *gabs.Container, data *map[string]interface{}, field
 		return validJsonField(fieldValue, field, data)
 	}
 
+	// 扩展类型：datetime、date、number、list、object
+	switch field.Type {
+	case TypeDatetime:
+		return validDatetimeField(fieldValue, field, data)
+	case TypeDate:
+		return validDateField(fieldValue, field, data)
+	case TypeNumber:
+		return validNumberField(fieldValue, field, data)
+	case TypeList:
+		return validListField(fieldValue, field, data)
+	case TypeObject:
+		return validObjectField(snapshot, fieldValue, field, data)
+	}
+
 	// 如果是枚举，查询该字段配置的枚举值，判断上报值是否在枚举值中
 	if field.Type == TypeEnum {
-		exists := cache.FieldEnumValueExists(field.Field, fieldValue.(string))
+		enumValue, ok := fieldValue.(string)
+		if !ok {
+			return typeMismatch(fieldValue, field)
+		}
+		exists := snapshot.FieldEnumValueExists(field.Field, enumValue)
 		if !exists {
-			return &ValidResult{OK: false, Err: "field: " + field.Name + " value: " + fieldValue.(string) + " not exists!", ErrType: ValueNotExist}
+			return &ValidResult{OK: false, Err: "field: " + field.Name + " value: " + enumValue + " not exists!", ErrType: ValueNotExist}
 		}
 
-		(*data)[field.Field] = fieldValue.(string)
+		(*data)[field.Field] = enumValue
 
 		return &ValidResult{OK: true, ErrType: None}
 	}
@@ -209,23 +403,38 @@ func validJsonField(fieldValue interface{}, field dao.DbpField, data *map[string
 }
 
 // 针对基本类型（int、float、long、string、bool），转换为对应类型并存入值map中
+// 类型断言失败（上报值类型与字段类型不一致）时返回类型不匹配
 func extractValueAndValidLength(fieldValue interface{}, field dao.DbpField, data *map[string]interface{}) *ValidResult {
 	switch field.Type {
-	case "float":
-		(*data)[field.Field] = fieldValue.(float64)
-	case "int":
-		(*data)[field.Field] = int(fieldValue.(float64))
-	case "long": // golang 里的long是int64
-		(*data)[field.Field] = int64(fieldValue.(float64))
-	case "string":
-		strVal := fieldValue.(string)
+	case TypeFloat, TypeInt, TypeLong:
+		number, ok := fieldValue.(float64)
+		if !ok {
+			return typeMismatch(fieldValue, field)
+		}
+		switch field.Type {
+		case TypeFloat:
+			(*data)[field.Field] = number
+		case TypeInt:
+			(*data)[field.Field] = int(number)
+		default: // golang 里的long是int64
+			(*data)[field.Field] = int64(number)
+		}
+	case TypeString:
+		strVal, ok := fieldValue.(string)
+		if !ok {
+			return typeMismatch(fieldValue, field)
+		}
 		// 如果字符串长度验证不通过，返回校验结果
 		if vr := validStringValueLength(strVal, field); !vr.OK {
 			return vr
 		}
 		(*data)[field.Field] = strVal
-	case "bool":
-		(*data)[field.Field] = fieldValue.(bool)
+	case TypeBool:
+		boolVal, ok := fieldValue.(bool)
+		if !ok {
+			return typeMismatch(fieldValue, field)
+		}
+		(*data)[field.Field] = boolVal
 	}
 
 	return &ValidResult{OK: true, ErrType: None}
@@ -246,39 +455,158 @@ func validStringValueLength(value string, field dao.DbpField) *ValidResult {
 }
 
 // ParseAndValidLogData valid logger data
-func ParseAndValidLogData(data []byte) (bool, error) {
-	logger.Logger.Info("parsed origin data: " + string(data))
+// 按数据类型（type）分发：track、track_signup 为行为事件，profile_* 为用户属性，item_* 为物品数据
+// 返回数据的 sdk 类型指标标签值（getLib），解析失败时为 metrics.UnknownLib
+func ParseAndValidLogData(data []byte) (string, bool, error) {
+	logger.PayloadLogger.Debug("parsed origin data", zap.ByteString("data", data))
 	jsonParsed, err := gabs.ParseJSON(data)
 	if err != nil {
 		logger.Logger.Info("gabs parse json has error: " + err.Error())
 		kafka.WriteErrorMsg(&ReportError{Err: "parse logger failed", ErrType: ParsedFailed, Data: string(data)})
-		return false, errors.New(err.Error())
+		metrics.EventsRejectedTotal.WithLabelValues(ParsedFailed.String(), "").Inc()
+		return metrics.UnknownLib, false, errors.New(err.Error())
+	}
+	ok, err := validLogData(jsonParsed, data)
+	return getLib(jsonParsed), ok, err
+}
+
+// validLogData 按数据类型校验已解析的单条数据并发送
+func validLogData(jsonParsed *gabs.Container, data []byte) (bool, error) {
+	// 整条数据使用同一个元数据快照校验
+	snapshot := cache.Snapshot()
+	dataType := getDataType(jsonParsed)
+	switch {
+	case dataType == DataTypeTrack || dataType == DataTypeTrackSignup:
+		return validTrackData(snapshot, jsonParsed, dataType, data)
+	case profileDataTypes[dataType]:
+		return validProfileData(snapshot, jsonParsed, dataType, data)
+	case dataType == DataTypeItemSet || dataType == DataTypeItemDelete:
+		return validItemData(snapshot, jsonParsed, dataType, data)
+	}
+
+	return reject(DataTypeUndefined, DataType, "Unknown type :"+dataType, data)
+}
+
+// getDataType 获取数据类型，未上报 type 时按 track 处理
+func getDataType(jsonParsed *gabs.Container) string {
+	if dataType, ok := jsonParsed.Path(DataTypeJsonPath).Data().(string); ok && dataType != "" {
+		return dataType
 	}
+	return DataTypeTrack
+}
+
+// validTrackData 校验行为事件（track、track_signup）数据并发送
+func validTrackData(snapshot *cache.MetadataSnapshot, jsonParsed *gabs.Container, dataType string, data []byte) (bool, error) {
 	validDataMap := make(map[string]interface{})
-	ok, err := validEvent(jsonParsed, &validDataMap)
+	ok, err := validEvent(snapshot, jsonParsed, &validDataMap)
 	if !ok {
-		kafka.WriteErrorMsg(&ReportError{
-			Err:     err.Error(),
-			ErrType: EventUndefined,
-			Data:    string(data),
-		})
-		return false, errors.New(err.Error())
-	}
-	// 查询元数据中所有的字段，依次进行验证
-	fields := cache.GetAllFieldLocal()
-	for _, field := range *fields {
-		validResult := validField(jsonParsed, &validDataMap, field)
-		if !validResult.OK { // 字段验证失败
-			kafka.WriteErrorMsg(&ReportError{Err: validResult.Err, ErrType: validResult.ErrType, Data: string(data)})
-			return false, errors.New(validResult.Err)
+		recordUndefinedEvent(jsonParsed, data)
+		return reject(EventUndefined, Event, err.Error(), data)
+	}
+	// 开启后输出数据类型，未开启时保持原有的行为事件数据格式
+	if handlerConf.TrackDataType {
+		validDataMap[DataType] = dataType
+	}
+
+	// track_signup 需要上报匿名 id（original_id）
+	if dataType == DataTypeTrackSignup {
+		originalId, ok := jsonParsed.Path(OriginalId).Data().(string)
+		if !ok || originalId == "" {
+			return reject(ValueCannotBeNull, OriginalId, "field ["+OriginalId+"] can not be null", data)
 		}
+		validDataMap[OriginalId] = originalId
+	}
+
+	// 查询事件需要校验的字段（事件字段配置优先，未配置则为全部字段），按事件的处理方式处理未定义属性后依次进行验证
+	event := validDataMap[Event].(string)
+	fields := snapshot.FieldsByEvent(event)
+	fieldErrors := handleUnknownProperties(snapshot, jsonParsed, event, fields, &validDataMap)
+	if len(fieldErrors) == 0 || handlerConf.ValidationMode == ValidationModeCollectAll {
+		fieldErrors = append(fieldErrors, validFields(snapshot, jsonParsed, &validDataMap, fields, eventPolicy(snapshot, event))...)
+	}
+	if len(fieldErrors) > 0 {
+		return rejectFieldErrors(fieldErrors, data)
 	}
+	if dataType == DataTypeTrackSignup {
+		linkIdentity(jsonParsed, validDataMap[OriginalId].(string), validDataMap[Event].(string))
+	}
+	fillUserKey(jsonParsed, &validDataMap)
 	FillReceiveTimeField(&validDataMap)
 	// 发送验证后的数据
-	kafka.WriteLogMsg(&validDataMap)
+	if err := kafka.WriteLogMsg(&validDataMap); err != nil {
+		return reject(InvalidFormat, Event, "failed to marshal valid data: "+err.Error(), data)
+	}
+	metrics.EventsAcceptedTotal.WithLabelValues(validDataMap[Event].(string)).Inc()
 	return true, nil
 }
 
+// validFields 依次校验字段，校验通过的字段数据放入 data 中
+// 校验失败的字段按处理策略（字段未配置时为 defaultPolicy）修正，修正失败时记为字段校验错误
+// failFast 模式遇到第一个错误即返回，collectAll 模式返回所有字段的校验错误
+func validFields(snapshot *cache.MetadataSnapshot, jsonParsed *gabs.Container, data *map[string]interface{}, fields []dao.DbpField,
+	defaultPolicy string) []FieldError {
+	var fieldErrors []FieldError
+	for _, field := range fields {
+		validResult := validField(snapshot, jsonParsed, data, field)
+		if !validResult.OK && !applyPolicy(snapshot, jsonParsed, data, field, validResult, fieldPolicy(field, defaultPolicy)) { // 字段验证失败
+			fieldErrors = append(fieldErrors, FieldError{Field: field.Field, ErrType: validResult.ErrType, Message: validResult.Err})
+			if handlerConf.ValidationMode != ValidationModeCollectAll {
+				break
+			}
+		}
+	}
+	return fieldErrors
+}
+
+// rejectFieldErrors 发送字段校验错误至异常 Topic
+func rejectFieldErrors(fieldErrors []FieldError, data []byte) (bool, error) {
+	countRejectedFields(fieldErrors)
+	if handlerConf.ValidationMode != ValidationModeCollectAll {
+		kafka.WriteErrorMsg(&ReportError{Err: fieldErrors[0].Message, ErrType: fieldErrors[0].ErrType, Data: string(data)})
+		return false, errors.New(fieldErrors[0].Message)
+	}
+	// 收集的所有字段校验错误统一上报
+	reportError := newFieldErrorsReport(fieldErrors, data)
+	kafka.WriteErrorMsg(reportError)
+	return false, errors.New(reportError.Err)
+}
+
+// 字段名来自上报数据（行为事件、用户属性未定义的属性名）的错误类型，指标的 field 标签为空，属性名只记录在异常信息中，
+// 避免任意属性名产生无限的指标序列
+var unboundedFieldErrTypes = map[ErrType]bool{PropertyUndefined: true, FieldUndefined: true}
+
+// countRejectedFields 按错误类型和字段统计字段校验错误
+func countRejectedFields(fieldErrors []FieldError) {
+	for _, fieldError := range fieldErrors {
+		field := fieldError.Field
+		if unboundedFieldErrTypes[fieldError.ErrType] {
+			field = ""
+		}
+		metrics.EventsRejectedTotal.WithLabelValues(fieldError.ErrType.String(), field).Inc()
+	}
+}
+
+// reject 发送校验错误至异常 Topic
+func reject(errType ErrType, field string, err string, data []byte) (bool, error) {
+	kafka.WriteErrorMsg(&ReportError{Err: err, ErrType: errType, Data: string(data)})
+	metrics.EventsRejectedTotal.WithLabelValues(errType.String(), field).Inc()
+	return false, errors.New(err)
+}
+
+// newFieldErrorsReport 合并多个字段校验错误为一条异常信息，ErrType 取第一个错误的类型
+func newFieldErrorsReport(fieldErrors []FieldError, data []byte) *ReportError {
+	messages := make([]string, 0, len(fieldErrors))
+	for _, fieldError := range fieldErrors {
+		messages = append(messages, fieldError.Message)
+	}
+	return &ReportError{
+		Err:     strings.Join(messages, "; "),
+		ErrType: fieldErrors[0].ErrType,
+		Errors:  fieldErrors,
+		Data:    string(data),
+	}
+}
+
 // FillReceiveTimeField 填充服务端接收时间
 func FillReceiveTimeField(dataMap *map[string]interface{}) {
 	(*dataMap)[ReceiveTime] = time.Now().UnixMilli()
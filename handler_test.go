@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/Jeffail/gabs"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"liangck.xyz/data-service/sensors-log-acceptor/dao"
+	"liangck.xyz/data-service/sensors-log-acceptor/metrics"
+	. "liangck.xyz/data-service/sensors-log-acceptor/model"
+	"testing"
+)
+
+func TestNewFieldErrorsReport(t *testing.T) {
+	fieldErrors := []FieldError{
+		{Field: "distinct_id", ErrType: ValueCannotBeNull, Message: "field [distinct_id] can not be null"},
+		{Field: "material_position", ErrType: TypeMisMatch, Message: "Field: [material_position] type mismatch"},
+	}
+	reportError := newFieldErrorsReport(fieldErrors, []byte(`{"event":"page_view"}`))
+	if reportError.ErrType != ValueCannotBeNull {
+		t.Errorf("ErrType should be the first error type, got %d", reportError.ErrType)
+	}
+	if reportError.Err != "field [distinct_id] can not be null; Field: [material_position] type mismatch" {
+		t.Errorf("unexpected Err: %s", reportError.Err)
+	}
+
+	marshaled, _ := json.Marshal(reportError)
+	var decoded map[string]interface{}
+	_ = json.Unmarshal(marshaled, &decoded)
+	if errs, ok := decoded["Errors"].([]interface{}); !ok || len(errs) != 2 {
+		t.Errorf("Errors should contain 2 entries, got %s", string(marshaled))
+	}
+}
+
+func TestGetDataType(t *testing.T) {
+	cases := map[string]string{
+		`{"event":"page_view"}`:                     DataTypeTrack,
+		`{"type":"track_signup","event":"$SignUp"}`: DataTypeTrackSignup,
+		`{"type":"profile_set","distinct_id":"u1"}`: DataTypeProfileSet,
+	}
+	for data, expected := range cases {
+		jsonParsed, _ := gabs.ParseJSON([]byte(data))
+		if dataType := getDataType(jsonParsed); dataType != expected {
+			t.Errorf("type of %s should be %s, got %s", data, expected, dataType)
+		}
+	}
+}
+
+func TestValidUndefinedProperties(t *testing.T) {
+	handlerConf = &HandlerConf{ValidationMode: ValidationModeCollectAll}
+	fields := []dao.DbpField{{Field: "gender", JsonPath: "properties.gender", Type: TypeString}}
+	jsonParsed, _ := gabs.ParseJSON([]byte(`{"type":"profile_set","properties":{"gender":"f","age":18,"vip":true,"$is_login_id":true}}`))
+
+	fieldErrors := validUndefinedProperties(jsonParsed, fields)
+	if len(fieldErrors) != 2 || fieldErrors[0].Field != "age" || fieldErrors[1].Field != "vip" {
+		t.Fatalf("age and vip should be undefined, got %v", fieldErrors)
+	}
+	if fieldErrors[0].ErrType != FieldUndefined {
+		t.Errorf("ErrType should be FieldUndefined, got %s", fieldErrors[0].ErrType)
+	}
+
+	// 未定义的属性名只记录在异常信息中，指标的 field 标签为空
+	series := testutil.CollectAndCount(metrics.EventsRejectedTotal)
+	countRejectedFields(fieldErrors)
+	if count := testutil.ToFloat64(metrics.EventsRejectedTotal.WithLabelValues(FieldUndefined.String(), "")); count != 2 {
+		t.Errorf("undefined profile properties should be counted without field label, got %v", count)
+	}
+	if count := testutil.CollectAndCount(metrics.EventsRejectedTotal); count != series+1 {
+		t.Errorf("undefined profile properties should add only one series, got %d more", count-series)
+	}
+
+	handlerConf = &HandlerConf{ValidationMode: ValidationModeFailFast}
+	if fieldErrors := validUndefinedProperties(jsonParsed, fields); len(fieldErrors) != 1 {
+		t.Errorf("failFast should stop at first undefined property, got %v", fieldErrors)
+	}
+}
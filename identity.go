@@ -0,0 +1,58 @@
+package main
+
+import (
+	"github.com/Jeffail/gabs"
+	"liangck.xyz/data-service/sensors-log-acceptor/identity"
+	"liangck.xyz/data-service/sensors-log-acceptor/kafka"
+	"liangck.xyz/data-service/sensors-log-acceptor/logger"
+)
+
+const LoginId = "login_id"
+const UserKey = "user_key"
+const TimeJsonPath = "time"
+
+// linkIdentity track_signup 关联匿名 id（original_id）与登录 id（distinct_id），新建关联时发送关联事件
+// 关联失败（redis 不可用）不影响数据接收
+func linkIdentity(jsonParsed *gabs.Container, originalId string, event string) {
+	if !identity.Enabled() {
+		return
+	}
+	distinctId, ok := jsonParsed.Path(DistinctId).Data().(string)
+	if !ok || distinctId == "" {
+		return
+	}
+	result, err := identity.Link(originalId, distinctId)
+	if err != nil {
+		logger.Logger.Error("failed to link [" + originalId + "] to [" + distinctId + "]. caused by: " + err.Error())
+		return
+	}
+	if result != identity.LinkCreated {
+		return
+	}
+
+	linkMap := map[string]interface{}{
+		Event:      event,
+		OriginalId: originalId,
+		DistinctId: distinctId,
+	}
+	if eventTime := jsonParsed.Path(TimeJsonPath).Data(); eventTime != nil {
+		linkMap[TimeJsonPath] = eventTime
+	}
+	FillReceiveTimeField(&linkMap)
+	kafka.WriteLinkMsg(&linkMap)
+}
+
+// fillUserKey 补充用户标识 user_key，用于拼接用户登录前后的行为：
+// 上报了 login_id 时为 login_id，否则为 distinct_id 关联的登录 id，未关联时为 distinct_id
+func fillUserKey(jsonParsed *gabs.Container, dataMap *map[string]interface{}) {
+	if !identity.Enabled() {
+		return
+	}
+	if loginId, ok := jsonParsed.Path(LoginId).Data().(string); ok && loginId != "" {
+		(*dataMap)[UserKey] = loginId
+		return
+	}
+	if distinctId, ok := jsonParsed.Path(DistinctId).Data().(string); ok && distinctId != "" {
+		(*dataMap)[UserKey] = identity.UserKey(distinctId)
+	}
+}
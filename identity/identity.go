@@ -0,0 +1,171 @@
+package identity
+
+import (
+	"context"
+	"errors"
+	"github.com/go-redis/redis/v8"
+	"github.com/patrickmn/go-cache"
+	"liangck.xyz/data-service/sensors-log-acceptor/configer"
+	"liangck.xyz/data-service/sensors-log-acceptor/logger"
+	"liangck.xyz/data-service/sensors-log-acceptor/metrics"
+	"sync/atomic"
+	"time"
+)
+
+// -------------------- Identity. 匿名 id 与登录 id 关联
+// 用户登录时 sdk 上报 track_signup，original_id 为登录前的匿名 id，distinct_id 为登录 id。
+// 关联关系保存在 redis 中（与 cache 使用同一个 redis），多个实例共享：
+//	key：DBP:IDENTITY:{匿名 id}，value：登录 id
+// 与神策一致，一个匿名 id 只能关联一个登录 id，先关联的生效（SETNX），之后的关联视为冲突。
+// 查询在数据接收的请求中同步执行，不能拖慢数据接收：
+//	关联关系建立后不会再变，进程内缓存查询到的关联关系；未关联的匿名 id（大部分数据）缓存 negativeExpiration，
+//	其他实例建立关联后，本实例最迟 negativeExpiration 后查询到；
+//	使用单独的 redis 连接，超时时间短（identity.timeout）且不重试；
+//	redis 访问失败后 breakerCooldown 内不再访问 redis（熔断），user_key 为 distinct_id。
+//------------------------
+
+const KeyPrefix = "DBP:IDENTITY:"
+
+// 本地缓存已查询到的关联关系、未关联的匿名 id 的过期时间
+const localExpiration = 10 * time.Minute
+const negativeExpiration = 30 * time.Second
+
+// redis 访问失败后暂停访问的时间
+const breakerCooldown = 5 * time.Second
+
+// redis 读写超时默认值
+const defaultTimeout = 100 * time.Millisecond
+
+// ErrUnavailable redis 访问失败后熔断期间的错误
+var ErrUnavailable = errors.New("identity redis is unavailable")
+
+// 关联结果，用于统计
+const LinkCreated = "created"   // 新建关联
+const LinkExists = "exists"     // 已关联相同的登录 id
+const LinkConflict = "conflict" // 已关联其他登录 id，本次关联被忽略
+
+var conf *Conf
+var redisClient *redis.Client
+var ctx = context.TODO()
+var localCache = cache.New(localExpiration, 2*localExpiration)
+
+// 熔断结束时间（unix 纳秒）
+var breakerOpenUntil int64
+
+// Conf identity configuration
+type Conf struct {
+	Enable  bool
+	TTL     time.Duration // 关联关系在 redis 中的过期时间，0 为永不过期
+	Timeout time.Duration // redis 连接、读写超时时间
+}
+
+// Init 初始化，client 为 cache 的 redis 连接，使用相同的地址创建超时时间短、不重试的单独连接
+func Init(config *configer.Config, client *redis.Client) {
+	conf = &Conf{
+		Enable:  config.IdentityEnable,
+		TTL:     time.Duration(config.IdentityTTL) * 24 * time.Hour,
+		Timeout: time.Duration(config.IdentityTimeout) * time.Millisecond,
+	}
+	if conf.Timeout <= 0 {
+		conf.Timeout = defaultTimeout
+	}
+	if client == nil {
+		return
+	}
+	options := *client.Options()
+	options.DialTimeout = conf.Timeout
+	options.ReadTimeout = conf.Timeout
+	options.WriteTimeout = conf.Timeout
+	options.PoolTimeout = conf.Timeout
+	options.MaxRetries = -1
+	redisClient = redis.NewClient(&options)
+}
+
+// Close 关闭 redis 连接
+func Close() error {
+	if redisClient == nil {
+		return nil
+	}
+	return redisClient.Close()
+}
+
+// available redis 是否可访问（不在熔断期间）
+func available() bool {
+	return time.Now().UnixNano() >= atomic.LoadInt64(&breakerOpenUntil)
+}
+
+// fail 记录 redis 访问失败，breakerCooldown 内不再访问 redis
+func fail() {
+	atomic.StoreInt64(&breakerOpenUntil, time.Now().Add(breakerCooldown).UnixNano())
+}
+
+// Enabled 是否开启 id 关联
+func Enabled() bool {
+	return conf != nil && conf.Enable && redisClient != nil
+}
+
+func getKey(anonymousId string) string {
+	return KeyPrefix + anonymousId
+}
+
+// Link 关联匿名 id 与登录 id，返回关联结果（LinkCreated、LinkExists、LinkConflict）
+func Link(anonymousId string, loginId string) (string, error) {
+	if anonymousId == loginId {
+		return LinkExists, nil
+	}
+	if !available() {
+		metrics.IdentityLinksTotal.WithLabelValues("error").Inc()
+		return "", ErrUnavailable
+	}
+	created, err := redisClient.SetNX(ctx, getKey(anonymousId), loginId, conf.TTL).Result()
+	if err != nil {
+		fail()
+		metrics.IdentityLinksTotal.WithLabelValues("error").Inc()
+		return "", err
+	}
+
+	result := LinkCreated
+	if created {
+		localCache.Set(anonymousId, loginId, cache.DefaultExpiration)
+	} else {
+		result = LinkExists
+		if linked := lookup(anonymousId); linked != loginId {
+			result = LinkConflict
+			logger.Logger.Info("anonymous id [" + anonymousId + "] has been linked to [" + linked + "], ignore link to [" + loginId + "]")
+		}
+	}
+	metrics.IdentityLinksTotal.WithLabelValues(result).Inc()
+	return result, nil
+}
+
+// UserKey 获取用户标识：已关联登录 id 的匿名 id 返回登录 id，否则返回 distinctId 本身
+// redis 不可用时返回 distinctId，不影响数据接收
+func UserKey(distinctId string) string {
+	if linked := lookup(distinctId); linked != "" {
+		return linked
+	}
+	return distinctId
+}
+
+// lookup 查询匿名 id 关联的登录 id，未关联或 redis 不可用时返回空字符串
+func lookup(anonymousId string) string {
+	if x, found := localCache.Get(anonymousId); found {
+		return x.(string)
+	}
+	if !available() {
+		return ""
+	}
+	loginId, err := redisClient.Get(ctx, getKey(anonymousId)).Result()
+	if err == redis.Nil {
+		localCache.Set(anonymousId, "", negativeExpiration)
+		return ""
+	}
+	if err != nil {
+		fail()
+		metrics.IdentityLookupErrorsTotal.Inc()
+		logger.Logger.Debug("failed to lookup identity of [" + anonymousId + "]. caused by: " + err.Error())
+		return ""
+	}
+	localCache.Set(anonymousId, loginId, cache.DefaultExpiration)
+	return loginId
+}
@@ -0,0 +1,38 @@
+package identity
+
+import (
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+	"liangck.xyz/data-service/sensors-log-acceptor/configer"
+	"liangck.xyz/data-service/sensors-log-acceptor/logger"
+	"testing"
+)
+
+func TestUserKey(t *testing.T) {
+	logger.Logger = zap.NewNop()
+	// redis 不可用
+	Init(&configer.Config{IdentityEnable: true}, redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1}))
+	if !Enabled() {
+		t.Fatal("identity should be enabled")
+	}
+
+	if userKey := UserKey("anonymous-1"); userKey != "anonymous-1" {
+		t.Errorf("user key should fall back to distinct id when redis is unavailable, got %s", userKey)
+	}
+	// 访问失败后熔断，不再访问 redis
+	if available() {
+		t.Error("breaker should be open after redis failure")
+	}
+	if _, err := Link("anonymous-1", "login-1"); err != ErrUnavailable {
+		t.Errorf("link should fail fast when redis is unavailable, got %v", err)
+	}
+	if result, err := Link("login-1", "login-1"); err != nil || result != LinkExists {
+		t.Errorf("link to itself should be ignored, got %s %v", result, err)
+	}
+
+	// 已查询到的关联关系从本地缓存获取
+	localCache.Set("anonymous-2", "login-2", 0)
+	if userKey := UserKey("anonymous-2"); userKey != "login-2" {
+		t.Errorf("user key of linked anonymous id should be login id, got %s", userKey)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"github.com/Jeffail/gabs"
+	"liangck.xyz/data-service/sensors-log-acceptor/cache"
+	"liangck.xyz/data-service/sensors-log-acceptor/kafka"
+	"liangck.xyz/data-service/sensors-log-acceptor/metrics"
+	. "liangck.xyz/data-service/sensors-log-acceptor/model"
+)
+
+const ItemType = "item_type"
+const ItemId = "item_id"
+
+// validItemData 校验物品（item_set、item_delete）数据并发送
+// 物品类型需在 dbp_item_fields 中定义，item_set 按该物品类型的字段定义校验属性
+func validItemData(snapshot *cache.MetadataSnapshot, jsonParsed *gabs.Container, dataType string, data []byte) (bool, error) {
+	itemType, ok := jsonParsed.Path(ItemType).Data().(string)
+	if !ok || itemType == "" {
+		return reject(ValueCannotBeNull, ItemType, "field ["+ItemType+"] can not be null", data)
+	}
+	itemId, ok := jsonParsed.Path(ItemId).Data().(string)
+	if !ok || itemId == "" {
+		return reject(ValueCannotBeNull, ItemId, "field ["+ItemId+"] can not be null", data)
+	}
+	fields, ok := snapshot.ItemFields(itemType)
+	if !ok {
+		return reject(ItemTypeUndefined, ItemType, "Unknown item type :"+itemType, data)
+	}
+
+	validDataMap := map[string]interface{}{
+		DataType: dataType,
+		ItemType: itemType,
+		ItemId:   itemId,
+	}
+	if dataType == DataTypeItemSet {
+		if fieldErrors := validFields(snapshot, jsonParsed, &validDataMap, fields, handlerConf.Policy); len(fieldErrors) > 0 {
+			return rejectFieldErrors(fieldErrors, data)
+		}
+	}
+
+	FillReceiveTimeField(&validDataMap)
+	if err := kafka.WriteItemMsg(&validDataMap); err != nil {
+		return reject(InvalidFormat, DataType, "failed to marshal valid data: "+err.Error(), data)
+	}
+	metrics.DataAcceptedTotal.WithLabelValues(dataType).Inc()
+	return true, nil
+}
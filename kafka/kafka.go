@@ -7,32 +7,183 @@ import (
 	"github.com/segmentio/kafka-go"
 	"liangck.xyz/data-service/sensors-log-acceptor/configer"
 	"liangck.xyz/data-service/sensors-log-acceptor/logger"
+	"liangck.xyz/data-service/sensors-log-acceptor/metrics"
 	. "liangck.xyz/data-service/sensors-log-acceptor/model"
+	"liangck.xyz/data-service/sensors-log-acceptor/spool"
+	"strconv"
 	"strings"
 	"time"
 )
 
+const defaultErrTopic = "user_event_log_err"
+const defaultProfileTopic = "user_profile"
+const defaultItemTopic = "item"
+const defaultLinkTopic = "user_identity_link"
+const defaultMessageKeyField = "distinct_id"
+const eventField = "event"
+const itemTypeField = "item_type"
+const itemIdField = "item_id"
+const replayBatchSize = 100
+const defaultReplayInterval = 10 * time.Second
+
 var kafkaConf *Conf
 var producer *Producer
 
+// 本地落盘队列，kafka 发送失败的消息写入此队列，kafka 恢复后回放
+var messageSpool *spool.Spool
+
+// 停止回放任务，取消进行中的回放发送
+var stopReplay = make(chan struct{})
+var replayCtx, cancelReplay = context.WithCancel(context.Background())
+
+// 回放任务退出后关闭
+var replayStopped = make(chan struct{})
+
 // Init 初始化kafka config producer
 func Init(config *configer.Config) {
 	kafkaConf = &Conf{
-		Brokers: config.KafkaBrokers,     // "192.168.3.212:9092",
-		Topic:   config.KafkaLogMsgTopic, // "user_event_log",
+		Brokers:         config.KafkaBrokers,      // "192.168.3.212:9092",
+		Topic:           config.KafkaLogMsgTopic,  // "user_event_log",
+		ErrTopic:        config.KafkaErrMsgTopic,  // "user_event_log_err",
+		ProfileTopic:    config.KafkaProfileTopic, // "user_profile",
+		ItemTopic:       config.KafkaItemTopic,    // "item",
+		LinkTopic:       config.KafkaLinkTopic,    // "user_identity_link",
+		ErrTopicRoutes:  parseErrTopicRoutes(config.KafkaErrTopicRoutes),
+		MessageKey:      config.KafkaMessageKey,
+		EventMessageKey: lowerKeys(config.KafkaEventMessageKeys),
+	}
+	// 未配置 topic 时使用默认 topic，避免用户属性、物品、id 关联数据发送到空 topic
+	if kafkaConf.ProfileTopic == "" {
+		kafkaConf.ProfileTopic = defaultProfileTopic
+	}
+	if kafkaConf.ItemTopic == "" {
+		kafkaConf.ItemTopic = defaultItemTopic
+	}
+	if kafkaConf.LinkTopic == "" {
+		kafkaConf.LinkTopic = defaultLinkTopic
+	}
+	if config.SpoolEnable {
+		initSpool(config)
 	}
 	producer = NewProducer(kafkaConf)
 }
 
+// initSpool 初始化本地落盘队列并启动回放任务
+func initSpool(config *configer.Config) {
+	s, err := spool.Open(&spool.Conf{
+		Dir:             config.SpoolDir,
+		SegmentMaxBytes: int64(config.SpoolSegmentMaxSize) * 1024 * 1024,
+		MaxBytes:        int64(config.SpoolMaxSize) * 1024 * 1024,
+	})
+	if err != nil {
+		logger.Logger.Error("Failed to open spool " + config.SpoolDir + ". caused by: " + err.Error())
+		return
+	}
+	messageSpool = s
+	metrics.RegisterSpoolStats(s.Stats)
+
+	interval := time.Duration(config.SpoolReplayInterval) * time.Second
+	if interval <= 0 {
+		interval = defaultReplayInterval
+	}
+	go replaySpool(interval)
+}
+
 // Conf kafka configuration
 type Conf struct {
-	Topic   string `toml:"kafka_topic"`
-	Brokers string `toml:"kafka_broker"`
+	Topic          string `toml:"kafka_topic"`
+	Brokers        string `toml:"kafka_broker"`
+	ErrTopic       string `toml:"kafka_err_topic"`
+	ProfileTopic   string `toml:"kafka_profile_topic"` // 用户属性数据 topic
+	ItemTopic      string `toml:"kafka_item_topic"`    // 物品数据 topic
+	LinkTopic      string `toml:"kafka_link_topic"`    // id 关联事件 topic
+	ErrTopicRoutes map[ErrType]string
+	// 消息 key 使用的字段（校验后的字段名），相同 key 的消息发送到同一分区，保证同一用户的事件有序
+	MessageKey string
+	// 按事件（事件名小写）覆盖消息 key 使用的字段
+	// 配置文件的 map key 会被转为小写（viper），所以按小写事件名匹配，只有大小写不同的事件会使用相同的配置
+	EventMessageKey map[string]string
+}
+
+// MessageKeyFields 消息 key 使用的字段（未配置时为 distinct_id）及按事件（事件名小写）覆盖的字段，用于启动时检查配置
+func MessageKeyFields() (string, map[string]string) {
+	keyField := kafkaConf.MessageKey
+	if keyField == "" {
+		keyField = defaultMessageKeyField
+	}
+	return keyField, kafkaConf.EventMessageKey
+}
+
+func lowerKeys(m map[string]string) map[string]string {
+	lowered := make(map[string]string, len(m))
+	for k, v := range m {
+		lowered[strings.ToLower(k)] = v
+	}
+	return lowered
+}
+
+// getMessageKey 从校验后的数据中提取消息 key，字段不存在时返回 nil（随机分区）
+func getMessageKey(conf *Conf, logMap *map[string]interface{}) []byte {
+	keyField := conf.MessageKey
+	if keyField == "" {
+		keyField = defaultMessageKeyField
+	}
+	if event, ok := (*logMap)[eventField].(string); ok {
+		if eventKeyField, ok := conf.EventMessageKey[strings.ToLower(event)]; ok && eventKeyField != "" {
+			keyField = eventKeyField
+		}
+	}
+
+	var key string
+	switch value := (*logMap)[keyField].(type) {
+	case string:
+		key = value
+	case float64:
+		key = strconv.FormatFloat(value, 'f', -1, 64)
+	case int:
+		key = strconv.Itoa(value)
+	case int64:
+		key = strconv.FormatInt(value, 10)
+	case bool:
+		key = strconv.FormatBool(value)
+	}
+	if key == "" {
+		return nil
+	}
+	return []byte(key)
+}
+
+// parseErrTopicRoutes 解析错误类型路由配置，key 为 ErrType 名称（如 EventUndefined、TypeMisMatch）
+func parseErrTopicRoutes(routes map[string]string) map[ErrType]string {
+	errTopicRoutes := make(map[ErrType]string)
+	for name, topic := range routes {
+		errType, ok := ParseErrType(name)
+		if !ok {
+			logger.Logger.Warn("unknown ErrType [" + name + "] in kafka.errTopicRoutes, ignored")
+			continue
+		}
+		if topic != "" {
+			errTopicRoutes[errType] = topic
+		}
+	}
+	return errTopicRoutes
+}
+
+// getErrTopic 获取错误类型对应的异常信息 topic，未配置路由时使用 ErrTopic
+func getErrTopic(conf *Conf, errType ErrType) string {
+	if topic, ok := conf.ErrTopicRoutes[errType]; ok {
+		return topic
+	}
+	if conf.ErrTopic != "" {
+		return conf.ErrTopic
+	}
+	return defaultErrTopic
 }
 
 type Producer struct {
-	kafkaConf   *Conf
-	kafkaWriter *kafka.Writer
+	kafkaConf    *Conf
+	kafkaWriter  *kafka.Writer
+	replayWriter *kafka.Writer // 同步发送，用于回放落盘的消息
 }
 
 // NewProducer create new kafka write instance
@@ -46,14 +197,131 @@ func NewProducer(kafkaConf *Conf) *Producer {
 	w := &kafka.Writer{
 		Addr: kafka.TCP(brokerArr...),
 		//Topic:    kafkaConf.Topic,
-		Balancer: &kafka.LeastBytes{},
+		// 与 java 客户端默认分区算法一致（murmur2），方便下游按 key 关联
+		Balancer: &kafka.Murmur2Balancer{},
 		Async:    true,
+		// 异步发送的结果只能在回调中获取，发送失败的消息写入本地落盘队列
+		Completion: func(messages []kafka.Message, err error) {
+			observeWrite(messages, err)
+			if err != nil {
+				spoolMessages(messages, err)
+			}
+		},
 	}
 
 	producer.kafkaWriter = w
+	producer.replayWriter = &kafka.Writer{
+		Addr:     kafka.TCP(brokerArr...),
+		Balancer: &kafka.Murmur2Balancer{},
+	}
 	return producer
 }
 
+// writeMessages 异步发送消息，发送失败的消息写入本地落盘队列
+func writeMessages(messages ...kafka.Message) error {
+	// 记录提交时间，用于统计发送耗时
+	now := time.Now()
+	for i := range messages {
+		messages[i].Time = now
+	}
+	err := producer.kafkaWriter.WriteMessages(context.Background(), messages...)
+	if err != nil {
+		observeWrite(messages, err)
+		spoolMessages(messages, err)
+	}
+	return err
+}
+
+// observeWrite 统计消息发送耗时和失败数
+func observeWrite(messages []kafka.Message, err error) {
+	for _, message := range messages {
+		if err != nil {
+			metrics.KafkaWriteErrorsTotal.WithLabelValues(message.Topic).Inc()
+			continue
+		}
+		metrics.KafkaWriteDuration.WithLabelValues(message.Topic).Observe(time.Since(message.Time).Seconds())
+	}
+}
+
+// spoolMessages 发送失败的消息写入本地落盘队列，未开启落盘时消息丢失
+func spoolMessages(messages []kafka.Message, cause error) {
+	logger.Logger.Error("Failed to send " + strconv.Itoa(len(messages)) + " msgs to kafka. caused by: " + cause.Error())
+	if messageSpool == nil {
+		return
+	}
+	records := make([]spool.Record, 0, len(messages))
+	for _, message := range messages {
+		records = append(records, spool.Record{Topic: message.Topic, Key: message.Key, Value: message.Value})
+	}
+	if err := messageSpool.Append(records...); err != nil {
+		logger.Logger.Error("Failed to append msgs to spool. caused by: " + err.Error())
+	}
+}
+
+// replaySpool 定时回放落盘的消息，发送失败（kafka 仍不可用）时等待下次回放
+func replaySpool(interval time.Duration) {
+	defer close(replayStopped)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopReplay:
+			return
+		case <-ticker.C:
+		}
+		stats := messageSpool.Stats()
+		if stats.Records == 0 {
+			continue
+		}
+		logger.Logger.Info("replay spool. backlog segments: " + strconv.Itoa(stats.Segments) +
+			", records: " + strconv.FormatInt(stats.Records, 10) +
+			", bytes: " + strconv.FormatInt(stats.Bytes, 10) +
+			", dropped: " + strconv.FormatInt(stats.Dropped, 10))
+		err := messageSpool.Replay(replayBatchSize, func(records []spool.Record) error {
+			messages := make([]kafka.Message, 0, len(records))
+			for _, record := range records {
+				messages = append(messages, kafka.Message{Topic: record.Topic, Key: record.Key, Value: record.Value})
+			}
+			return producer.replayWriter.WriteMessages(replayCtx, messages...)
+		})
+		if err != nil {
+			logger.Logger.Warn("Failed to replay spool. caused by: " + err.Error())
+		}
+	}
+}
+
+// Close flush 并关闭 producer
+// 异步 writer 关闭时会发送完缓冲中的消息，发送失败的消息仍会写入落盘队列，所以最后关闭落盘队列
+func Close() error {
+	close(stopReplay)
+	cancelReplay()
+	// 等待回放任务退出后再关闭回放使用的 writer 和落盘队列
+	if messageSpool != nil {
+		<-replayStopped
+	}
+	if producer == nil {
+		return nil
+	}
+	err := producer.kafkaWriter.Close()
+	if err2 := producer.replayWriter.Close(); err == nil {
+		err = err2
+	}
+	if messageSpool != nil {
+		if err2 := messageSpool.Close(); err == nil {
+			err = err2
+		}
+	}
+	return err
+}
+
+// SpoolStats 获取本地落盘队列积压情况，未开启落盘时返回 false
+func SpoolStats() (spool.Stats, bool) {
+	if messageSpool == nil {
+		return spool.Stats{}, false
+	}
+	return messageSpool.Stats(), true
+}
+
 // WriteErrorMsg 发送异常信息至异常信息Topic
 func WriteErrorMsg(error *ReportError) {
 	error.Time = time.Now().UnixMilli()
@@ -66,11 +334,12 @@ func WriteErrorMsg(error *ReportError) {
 	errorJson, err := json.Marshal(error)
 	if err != nil {
 		logger.Logger.Error("Failed to Marshal error msg . caused by: " + err.Error())
+		return
 	}
 
-	err2 := producer.kafkaWriter.WriteMessages(context.Background(),
+	err2 := writeMessages(
 		kafka.Message{
-			Topic: "user_event_log_err",
+			Topic: getErrTopic(kafkaConf, error.ErrType),
 			Value: errorJson,
 		},
 	)
@@ -80,21 +349,51 @@ func WriteErrorMsg(error *ReportError) {
 	}
 }
 
-// WriteLogMsg 发送（验证通过的）上报行为日志数据
-func WriteLogMsg(logMap *map[string]interface{}) {
-	logJson, err := json.Marshal(logMap)
+// WriteLogMsg 发送（验证通过的）上报行为日志数据，数据无法序列化时返回错误，不发送
+func WriteLogMsg(logMap *map[string]interface{}) error {
+	return writeDataMsg(kafkaConf.Topic, getMessageKey(kafkaConf, logMap), logMap)
+}
+
+// WriteProfileMsg 发送（验证通过的）用户属性数据（profile_*），按 distinct_id 分区
+func WriteProfileMsg(profileMap *map[string]interface{}) error {
+	return writeDataMsg(kafkaConf.ProfileTopic, getMessageKey(kafkaConf, profileMap), profileMap)
+}
+
+// WriteItemMsg 发送（验证通过的）物品数据（item_*），按 item_type、item_id 分区
+func WriteItemMsg(itemMap *map[string]interface{}) error {
+	var key []byte
+	itemType, _ := (*itemMap)[itemTypeField].(string)
+	itemId, _ := (*itemMap)[itemIdField].(string)
+	if itemId != "" {
+		key = []byte(itemType + ":" + itemId)
+	}
+	return writeDataMsg(kafkaConf.ItemTopic, key, itemMap)
+}
+
+// WriteLinkMsg 发送 id 关联事件（匿名 id 关联登录 id），按登录 id 分区
+func WriteLinkMsg(linkMap *map[string]interface{}) error {
+	return writeDataMsg(kafkaConf.LinkTopic, getMessageKey(kafkaConf, linkMap), linkMap)
+}
+
+// writeDataMsg 序列化并发送数据，序列化失败时返回错误，不发送空消息；
+// 发送失败的消息已写入本地落盘队列，只记录日志
+func writeDataMsg(topic string, key []byte, dataMap *map[string]interface{}) error {
+	dataJson, err := json.Marshal(dataMap)
 	if err != nil {
 		logger.Logger.Error("Failed to Marshal log msg. caused by: " + err.Error())
+		return err
 	}
 
-	err2 := producer.kafkaWriter.WriteMessages(context.Background(),
+	err2 := writeMessages(
 		kafka.Message{
-			Topic: kafkaConf.Topic,
-			Value: logJson,
+			Topic: topic,
+			Key:   key,
+			Value: dataJson,
 		},
 	)
 
 	if err2 != nil {
 		logger.Logger.Error("Failed to send log msg to kafka. caused by: " + err2.Error())
 	}
+	return nil
 }
@@ -0,0 +1,57 @@
+package kafka
+
+import (
+	"go.uber.org/zap"
+	"liangck.xyz/data-service/sensors-log-acceptor/logger"
+	. "liangck.xyz/data-service/sensors-log-acceptor/model"
+	"testing"
+)
+
+func TestGetErrTopic(t *testing.T) {
+	logger.Logger = zap.NewNop()
+	// viper 读取的 map key 为小写
+	conf := &Conf{
+		ErrTopic: "user_event_log_err",
+		ErrTopicRoutes: parseErrTopicRoutes(map[string]string{
+			"eventundefined": "user_event_log_err_undefined",
+			"TypeMisMatch":   "user_event_log_err_type",
+			"NotAnErrType":   "ignored",
+		}),
+	}
+	if len(conf.ErrTopicRoutes) != 2 {
+		t.Fatalf("unexpected routes %v", conf.ErrTopicRoutes)
+	}
+	cases := map[ErrType]string{
+		EventUndefined: "user_event_log_err_undefined",
+		TypeMisMatch:   "user_event_log_err_type",
+		ValueTooLong:   "user_event_log_err",
+	}
+	for errType, expected := range cases {
+		if topic := getErrTopic(conf, errType); topic != expected {
+			t.Errorf("%s should be routed to %s, got %s", errType, expected, topic)
+		}
+	}
+	if topic := getErrTopic(&Conf{}, ValueTooLong); topic != defaultErrTopic {
+		t.Errorf("should fall back to %s, got %s", defaultErrTopic, topic)
+	}
+}
+
+func TestGetMessageKey(t *testing.T) {
+	conf := &Conf{EventMessageKey: lowerKeys(map[string]string{"Pay_Order": "order_id"})}
+	logMap := map[string]interface{}{"event": "page_view", "distinct_id": "u1", "order_id": "o1"}
+	if key := getMessageKey(conf, &logMap); string(key) != "u1" {
+		t.Errorf("key should default to distinct_id, got %s", key)
+	}
+	logMap["event"] = "pay_order"
+	if key := getMessageKey(conf, &logMap); string(key) != "o1" {
+		t.Errorf("pay_order key should be order_id, got %s", key)
+	}
+	logMap["order_id"] = int64(1001)
+	if key := getMessageKey(conf, &logMap); string(key) != "1001" {
+		t.Errorf("numeric key should be formatted, got %s", key)
+	}
+	delete(logMap, "order_id")
+	if key := getMessageKey(conf, &logMap); key != nil {
+		t.Errorf("missing key field should return nil, got %s", key)
+	}
+}
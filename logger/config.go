@@ -1,16 +1,26 @@
 package logger
 
-import "go.uber.org/zap/zapcore"
+import (
+	"go.uber.org/zap"
+	"time"
+)
 
 // Config 日志配置
 type Config struct {
-	ServiceName         string        // 服务名称
-	EnableLogLevel      zapcore.Level // 开启的日志级别
-	ConsoleOutputEnable bool          // 开启输出到控制台
-	FileOutputEnable    bool          // 开启输出到文件
-	KafkaOutputEnable   bool          // 开启输出到kafka
-	FileConfig          FileConfig    // 文件配置
-	KafkaConfig         KafkaConfig   // kafka配置
+	ServiceName         string          // 服务名称
+	EnableLogLevel      zap.AtomicLevel // 开启的日志级别，可在运行时修改
+	ConsoleOutputEnable bool            // 开启输出到控制台
+	FileOutputEnable    bool            // 开启输出到文件
+	KafkaOutputEnable   bool            // 开启输出到kafka
+	FileConfig          FileConfig      // 文件配置
+	KafkaConfig         KafkaConfig     // kafka配置
+	PayloadSampling     SamplingConfig  // 上报数据日志采样配置
+}
+
+// SamplingConfig 采样配置，每秒内相同内容的日志前 First 条全部输出，之后每 Thereafter 条输出一条
+type SamplingConfig struct {
+	First      int
+	Thereafter int
 }
 
 // FileConfig 文件配置
@@ -24,6 +34,9 @@ type FileConfig struct {
 
 // KafkaConfig kafka配置
 type KafkaConfig struct {
-	brokers string // broker地址
-	topic   string // topic
+	Brokers      string        // broker地址，逗号分隔
+	Topic        string        // topic
+	BufferSize   int           // 缓冲区大小（日志条数），缓冲区满时丢弃日志
+	BatchSize    int           // 批量发送条数
+	BatchTimeout time.Duration // 批量发送最大等待时间
 }
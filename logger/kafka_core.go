@@ -0,0 +1,172 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"github.com/segmentio/kafka-go"
+	"go.uber.org/zap/zapcore"
+	"liangck.xyz/data-service/sensors-log-acceptor/metrics"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 日志输出到 kafka
+// 日志写入有界缓冲区后立即返回，由后台 goroutine 批量发送，缓冲区满时丢弃日志并计数，
+// 避免 kafka 不可用时阻塞业务请求。发送失败只能输出到 stderr（不能再写日志，否则会递归）。
+
+const defaultKafkaBufferSize = 10000
+const defaultKafkaBatchSize = 100
+const defaultKafkaBatchTimeout = time.Second
+
+// kafkaCore zapcore.Core 实现，With 派生的 core 共享同一个 kafkaSink
+type kafkaCore struct {
+	zapcore.LevelEnabler
+	encoder zapcore.Encoder
+	sink    *kafkaSink
+}
+
+type kafkaSink struct {
+	writer       *kafka.Writer
+	buffer       chan []byte
+	batchSize    int
+	batchTimeout time.Duration
+	done         chan struct{}
+
+	// 关闭后不再接收日志，避免向已关闭的缓冲区写入
+	mu     sync.RWMutex
+	closed bool
+}
+
+// newKafkaCore 创建输出到 kafka 的 core，并启动后台发送 goroutine
+func newKafkaCore(encoder zapcore.Encoder, config KafkaConfig, enabler zapcore.LevelEnabler) *kafkaCore {
+	bufferSize := config.BufferSize
+	if bufferSize <= 0 {
+		bufferSize = defaultKafkaBufferSize
+	}
+	batchSize := config.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultKafkaBatchSize
+	}
+	batchTimeout := config.BatchTimeout
+	if batchTimeout <= 0 {
+		batchTimeout = defaultKafkaBatchTimeout
+	}
+
+	sink := &kafkaSink{
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(strings.Split(config.Brokers, ",")...),
+			Topic:        config.Topic,
+			Balancer:     &kafka.LeastBytes{},
+			BatchSize:    batchSize,
+			BatchTimeout: batchTimeout,
+		},
+		buffer:       make(chan []byte, bufferSize),
+		batchSize:    batchSize,
+		batchTimeout: batchTimeout,
+		done:         make(chan struct{}),
+	}
+	go sink.run()
+
+	return &kafkaCore{LevelEnabler: enabler, encoder: encoder, sink: sink}
+}
+
+func (c *kafkaCore) With(fields []zapcore.Field) zapcore.Core {
+	encoder := c.encoder.Clone()
+	for _, field := range fields {
+		field.AddTo(encoder)
+	}
+	return &kafkaCore{LevelEnabler: c.LevelEnabler, encoder: encoder, sink: c.sink}
+}
+
+// withLevel 派生使用其他日志级别的 core，共享同一个 kafkaSink
+func (c *kafkaCore) withLevel(enabler zapcore.LevelEnabler) *kafkaCore {
+	return &kafkaCore{LevelEnabler: enabler, encoder: c.encoder.Clone(), sink: c.sink}
+}
+
+func (c *kafkaCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
+
+func (c *kafkaCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	buf, err := c.encoder.EncodeEntry(entry, fields)
+	if err != nil {
+		return err
+	}
+	// buf 会被复用，需要拷贝
+	msg := make([]byte, buf.Len())
+	copy(msg, buf.Bytes())
+	buf.Free()
+
+	c.sink.mu.RLock()
+	defer c.sink.mu.RUnlock()
+	if c.sink.closed {
+		metrics.LoggerKafkaDroppedTotal.Inc()
+		return nil
+	}
+	select {
+	case c.sink.buffer <- msg:
+	default:
+		metrics.LoggerKafkaDroppedTotal.Inc()
+	}
+	return nil
+}
+
+// Sync 异步发送，不等待
+func (c *kafkaCore) Sync() error {
+	return nil
+}
+
+// run 批量发送缓冲区中的日志，缓冲区关闭后发送完剩余日志再退出
+func (s *kafkaSink) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.batchTimeout)
+	defer ticker.Stop()
+
+	batch := make([]kafka.Message, 0, s.batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := s.writer.WriteMessages(context.Background(), batch...); err != nil {
+			metrics.LoggerKafkaDroppedTotal.Add(float64(len(batch)))
+			_, _ = fmt.Fprintln(os.Stderr, "failed to send logs to kafka: "+err.Error())
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case msg, ok := <-s.buffer:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, kafka.Message{Value: msg})
+			if len(batch) >= s.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// close 停止接收日志，等待缓冲区中的日志发送完成
+func (s *kafkaSink) close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.buffer)
+	s.mu.Unlock()
+
+	<-s.done
+	return s.writer.Close()
+}
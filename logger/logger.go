@@ -14,6 +14,22 @@ import (
 var Logger *zap.Logger
 var SugarLogger *zap.SugaredLogger
 
+// PayloadLogger 用于输出上报的原始数据，经过采样，避免高负载时日志写满磁盘
+// 采样按日志消息分组，消息需为常量，数据放在字段中（如 zap.ByteString("data", data)），否则每条日志都不同，采样不生效
+var PayloadLogger *zap.Logger
+
+// AuditLogger 管理接口审计日志，固定输出 info 及以上级别，不受 Level 影响（运行时调高日志级别后审计日志仍然输出）
+var AuditLogger *zap.Logger
+
+// Level 日志级别，可通过管理接口在运行时修改（实现了 http.Handler，GET 查询、PUT {"level":"info"} 修改）
+var Level = zap.NewAtomicLevel()
+
+const defaultSamplingFirst = 100
+const defaultSamplingThereafter = 100
+
+// 输出到 kafka 的 core，未开启时为 nil
+var kafkaOutput *kafkaCore
+
 // Init 初始化日志配置
 func Init(config *configer.Config) {
 	fileConfig := FileConfig{
@@ -23,33 +39,78 @@ func Init(config *configer.Config) {
 		MaxAge:     config.LogFileMaxAge,
 		FilePath:   config.LogFilePath,
 	}
+	kafkaConfig := KafkaConfig{
+		Brokers:      config.LoggerKafkaBrokers,
+		Topic:        config.LoggerKafkaTopic,
+		BufferSize:   config.LoggerKafkaBufferSize,
+		BatchSize:    config.LoggerKafkaBatchSize,
+		BatchTimeout: time.Duration(config.LoggerKafkaBatchTimeout) * time.Millisecond,
+	}
+	if err := Level.UnmarshalText([]byte(config.LoggerEnableLevel)); err != nil {
+		Level.SetLevel(zapcore.InfoLevel)
+	}
 	logConf := Config{
+		ServiceName:         config.ServiceName,
 		FileConfig:          fileConfig,
-		EnableLogLevel:      zapcore.DebugLevel,
+		KafkaConfig:         kafkaConfig,
+		EnableLogLevel:      Level,
+		PayloadSampling:     SamplingConfig{First: config.LoggerPayloadSamplingFirst, Thereafter: config.LoggerPayloadSamplingThereafter},
 		ConsoleOutputEnable: config.LoggerConsoleEnable,
 		FileOutputEnable:    config.LoggerFileEnable,
 		KafkaOutputEnable:   config.LoggerKafkaEnable,
 	}
 
-	var allCore []zapcore.Core
+	// 审计日志与其他日志使用相同的输出，级别固定为 info
+	var allCore, auditCore []zapcore.Core
 	encoder := getEncoder(&logConf)
 	if logConf.FileOutputEnable {
 		writeSyncer := getFileWriteSyncer(&logConf)
 		fileCore := zapcore.NewCore(encoder, writeSyncer, logConf.EnableLogLevel)
 		allCore = append(allCore, fileCore)
+		auditCore = append(auditCore, zapcore.NewCore(encoder, writeSyncer, zapcore.InfoLevel))
 	}
 	if logConf.ConsoleOutputEnable {
 		consoleWriter := zapcore.Lock(os.Stdout)
 		consoleCore := zapcore.NewCore(encoder, consoleWriter, logConf.EnableLogLevel)
 		allCore = append(allCore, consoleCore)
+		auditCore = append(auditCore, zapcore.NewCore(encoder, consoleWriter, zapcore.InfoLevel))
 	}
-	// todo
 	if logConf.KafkaOutputEnable {
-
+		serviceFields := []zapcore.Field{zap.String("service", logConf.ServiceName)}
+		kafkaOutput = newKafkaCore(getJsonEncoder(), logConf.KafkaConfig, logConf.EnableLogLevel)
+		allCore = append(allCore, kafkaOutput.With(serviceFields))
+		auditCore = append(auditCore, kafkaOutput.withLevel(zapcore.InfoLevel).With(serviceFields))
 	}
 	core := zapcore.NewTee(allCore...)
 	Logger = zap.New(core, zap.AddCaller())
+	AuditLogger = zap.New(zapcore.NewTee(auditCore...)).Named("audit")
 	SugarLogger = Logger.Sugar()
+	PayloadLogger = newSampledLogger(Logger.Named("payload"), logConf.PayloadSampling)
+}
+
+// newSampledLogger 基于 logger 创建采样的 logger
+func newSampledLogger(logger *zap.Logger, sampling SamplingConfig) *zap.Logger {
+	first := sampling.First
+	if first <= 0 {
+		first = defaultSamplingFirst
+	}
+	thereafter := sampling.Thereafter
+	if thereafter <= 0 {
+		thereafter = defaultSamplingThereafter
+	}
+	return logger.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return zapcore.NewSamplerWithOptions(core, time.Second, first, thereafter)
+	}))
+}
+
+// Close flush 并关闭日志输出（kafka），退出前调用
+func Close() error {
+	_ = Logger.Sync()
+	_ = AuditLogger.Sync()
+	if kafkaOutput != nil {
+		return kafkaOutput.sink.close()
+	}
+	return nil
 }
 
 func getFileWriteSyncer(config *Config) zapcore.WriteSyncer {
@@ -72,3 +133,10 @@ func getEncoder(config *Config) zapcore.Encoder {
 	encoderConfig.EncodeLevel = zapcore.CapitalLevelEncoder
 	return zapcore.NewConsoleEncoder(encoderConfig)
 }
+
+// getJsonEncoder 输出到 kafka 的日志使用 json 格式，方便日志平台解析
+func getJsonEncoder() zapcore.Encoder {
+	encoderConfig := zap.NewProductionEncoderConfig()
+	encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	return zapcore.NewJSONEncoder(encoderConfig)
+}
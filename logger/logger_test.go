@@ -1,6 +1,11 @@
 package logger
 
 import (
+	"encoding/json"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"liangck.xyz/data-service/sensors-log-acceptor/metrics"
 	"testing"
 )
 
@@ -22,3 +27,28 @@ func TestLog(t *testing.T) {
 	//SugarLogger.Debug("SugarLogger debug message")
 	//SugarLogger.Info("SugarLogger info message")
 }
+
+func TestKafkaCoreDropsWhenBufferFull(t *testing.T) {
+	sink := &kafkaSink{buffer: make(chan []byte, 1)}
+	core := &kafkaCore{LevelEnabler: zapcore.InfoLevel, encoder: getJsonEncoder(), sink: sink}
+	log := zap.New(core).With(zap.String("service", "sensors-log-acceptor"))
+
+	dropped := testutil.ToFloat64(metrics.LoggerKafkaDroppedTotal)
+	log.Debug("disabled level")
+	log.Info("first")
+	log.Info("second")
+
+	if len(sink.buffer) != 1 {
+		t.Fatalf("buffer should contain 1 entry, got %d", len(sink.buffer))
+	}
+	var entry map[string]interface{}
+	if err := json.Unmarshal(<-sink.buffer, &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry["msg"] != "first" || entry["service"] != "sensors-log-acceptor" {
+		t.Errorf("unexpected entry %v", entry)
+	}
+	if testutil.ToFloat64(metrics.LoggerKafkaDroppedTotal)-dropped != 1 {
+		t.Errorf("second entry should be dropped")
+	}
+}
@@ -1,13 +1,24 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"liangck.xyz/data-service/sensors-log-acceptor/cache"
 	"liangck.xyz/data-service/sensors-log-acceptor/configer"
 	"liangck.xyz/data-service/sensors-log-acceptor/dao"
+	"liangck.xyz/data-service/sensors-log-acceptor/discovery"
+	"liangck.xyz/data-service/sensors-log-acceptor/identity"
 	"liangck.xyz/data-service/sensors-log-acceptor/kafka"
 	"liangck.xyz/data-service/sensors-log-acceptor/logger"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
+const defaultShutdownTimeout = 30 * time.Second
+
 func main() {
 	config := configer.Init()
 
@@ -15,17 +26,99 @@ func main() {
 	logger.Init(config)
 	logger.Logger.Info("env: " + configer.GetString(configer.Env) + " , consulAddress: " + configer.GetString(configer.ConsulAddress))
 
-	// init db resource
-	dao.InitDb(config)
-	logger.Logger.Info("init database resources successful.")
+	// schema export/import subcommand, e.g. -e production schema import -f schema.yaml
+	if flag.Arg(0) == "schema" {
+		os.Exit(runSchemaCommand(config, flag.Args()[1:]))
+	}
+
+	// init db resource, metadata from file does not need database
+	if config.MetadataSource != cache.SourceFile {
+		dao.InitDb(config)
+		logger.Logger.Info("init database resources successful.")
+	}
 
 	// init cache resource
 	cache.Init(config)
 	logger.Logger.Info("init cache resources successful.")
 
+	// init identity, dedicated redis client with short timeouts derived from cache's client
+	identity.Init(config, cache.RedisClient())
+
 	// init kafka
 	kafka.Init(config)
 
+	// init handler
+	InitHandler(config)
+	checkMessageKeys(cache.Snapshot())
+
+	// init discovery, record undefined properties to database periodically
+	discovery.Init(config)
+
 	// init handler mapping and start gin
-	InitRouter(config)
+	servers := InitRouter(config)
+
+	// wait for SIGINT/SIGTERM and shutdown gracefully
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-quit
+	logger.Logger.Info("receive signal " + sig.String() + ", shutting down...")
+
+	timeout := time.Duration(config.ShutdownTimeout) * time.Second
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	shutdown(ctx, servers...)
+}
+
+// shutdown 优雅退出
+// 1.停止接收新连接（数据接收、管理接口），等待处理中的请求完成
+// 2.flush 并关闭 kafka producer（发送失败的消息会写入本地落盘队列）
+// 3.写入剩余的发现记录（未定义属性）
+// 4.关闭 redis 订阅、redis 连接和数据库连接池
+// 5.flush 并关闭日志输出
+// 超过 deadline 后不再等待，直接退出
+func shutdown(ctx context.Context, servers ...*http.Server) {
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			logger.Logger.Error("failed to shutdown http server " + srv.Addr + ": " + err.Error())
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := kafka.Close(); err != nil {
+			logger.Logger.Error("failed to close kafka producer: " + err.Error())
+		}
+		discovery.Close()
+		if err := identity.Close(); err != nil {
+			logger.Logger.Error("failed to close identity: " + err.Error())
+		}
+		if err := cache.Close(); err != nil {
+			logger.Logger.Error("failed to close cache: " + err.Error())
+		}
+		if err := dao.Close(); err != nil {
+			logger.Logger.Error("failed to close database: " + err.Error())
+		}
+	}()
+
+	select {
+	case <-done:
+		logger.Logger.Info("shutdown successful.")
+	case <-ctx.Done():
+		logger.Logger.Error("shutdown timeout, exit without waiting for resources to be released.")
+	}
+
+	// 日志输出到 kafka 时 flush 可能阻塞，同样不超过 deadline
+	loggerClosed := make(chan struct{})
+	go func() {
+		defer close(loggerClosed)
+		_ = logger.Close()
+	}()
+	select {
+	case <-loggerClosed:
+	case <-ctx.Done():
+	}
 }
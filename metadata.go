@@ -0,0 +1,926 @@
+package main
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"io"
+	"liangck.xyz/data-service/sensors-log-acceptor/cache"
+	"liangck.xyz/data-service/sensors-log-acceptor/constraint"
+	"liangck.xyz/data-service/sensors-log-acceptor/dao"
+	"liangck.xyz/data-service/sensors-log-acceptor/logger"
+	"liangck.xyz/data-service/sensors-log-acceptor/model"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+)
+
+// 元数据管理接口：事件、字段、事件字段配置、枚举值的增删改查
+// 写操作成功后发布对应的变更消息，各节点收到后刷新本地缓存；删除均为软删除。
+// 事件名、字段名是其他元数据引用的 key，创建后不允许修改，需要修改时删除后重建。
+
+// 事件名、字段名：字母、数字、下划线、$，不能以数字开头
+var namePattern = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]{0,99}$`)
+
+// json path：以 . 分隔的字段名，如 properties.page_id
+var jsonPathPattern = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*(\.[A-Za-z_$][A-Za-z0-9_$]*)*$`)
+
+// 支持的字段类型
+var fieldTypes = map[string]bool{
+	TypeBool:     true,
+	TypeFloat:    true,
+	TypeInt:      true,
+	TypeLong:     true,
+	TypeString:   true,
+	TypeEnum:     true,
+	TypeJson:     true,
+	TypeDatetime: true,
+	TypeDate:     true,
+	TypeNumber:   true,
+	TypeList:     true,
+	TypeObject:   true,
+}
+
+// EventRequest 事件
+type EventRequest struct {
+	Event       string
+	Description string
+	Policy      string // 字段校验失败时的默认处理策略
+	// 未定义属性的处理方式
+	UnknownProperties string
+}
+
+// FieldRequest 字段定义
+type FieldRequest struct {
+	Field       string
+	JsonPath    string
+	Type        string
+	Length      int
+	Name        string
+	Nullable    bool
+	ElementType string // list 类型元素类型
+	MaxElements int    // list 类型最大元素个数
+	Parent      string // object 类型子字段所属的 object 字段
+	Constraints *constraint.Constraints
+	Policy      string // 校验失败时的处理策略
+	Coerce      bool   // 上报值类型与字段类型不一致时是否先转换类型
+}
+
+// EventFieldRequest 事件字段配置
+type EventFieldRequest struct {
+	Event    string
+	Field    string
+	Nullable bool
+	// 配置时替换字段定义的约束、处理策略
+	Constraints *constraint.Constraints
+	Policy      string
+}
+
+// EnumValueRequest 枚举值
+type EnumValueRequest struct {
+	Field     string
+	EnumValue string
+	ValueName string
+}
+
+// metadataError 元数据管理接口错误，status 为响应的 http 状态码
+type metadataError struct {
+	status int
+	err    string
+}
+
+func (e *metadataError) Error() string {
+	return e.err
+}
+
+func badRequest(err string) error {
+	return &metadataError{status: http.StatusBadRequest, err: err}
+}
+
+func conflict(err string) error {
+	return &metadataError{status: http.StatusConflict, err: err}
+}
+
+// registerMetadataRoutes 注册元数据管理接口
+func registerMetadataRoutes(group *gin.RouterGroup) {
+	group.GET("/events", listEvents)
+	group.GET("/events/:id", getEvent)
+	group.POST("/events", createEvent)
+	group.PUT("/events/:id", updateEvent)
+	group.DELETE("/events/:id", deleteEvent)
+
+	group.GET("/fields", listFields)
+	group.GET("/fields/:id", getField)
+	group.POST("/fields", createField)
+	group.PUT("/fields/:id", updateField)
+	group.DELETE("/fields/:id", deleteField)
+
+	group.GET("/eventFields", listEventFields)
+	group.GET("/eventFields/:id", getEventField)
+	group.POST("/eventFields", createEventField)
+	group.PUT("/eventFields/:id", updateEventField)
+	group.DELETE("/eventFields/:id", deleteEventField)
+
+	group.GET("/enumValues", listEnumValues)
+	group.GET("/enumValues/:id", getEnumValue)
+	group.POST("/enumValues", createEnumValue)
+	group.PUT("/enumValues/:id", updateEnumValue)
+	group.DELETE("/enumValues/:id", deleteEnumValue)
+
+	group.GET("/discoveredFields", listDiscoveredFields)
+	group.DELETE("/discoveredFields/:id", deleteDiscoveredField)
+
+	group.GET("/discoveredEvents", listDiscoveredEvents)
+	group.DELETE("/discoveredEvents/:id", deleteDiscoveredEvent)
+	group.POST("/discoveredEvents/:id/promote", promoteDiscoveredEvent)
+
+	group.GET("/versions", getVersions)
+
+	group.GET("/schema", exportSchemaBundle)
+	group.POST("/schema", importSchemaBundle)
+}
+
+// ------------------ validation ----------------------
+
+func validEventRequest(request *EventRequest) error {
+	if !namePattern.MatchString(request.Event) {
+		return badRequest("invalid event name [" + request.Event + "]")
+	}
+	if err := validPolicy(request.Policy); err != nil {
+		return err
+	}
+	return validUnknownProperties(request.UnknownProperties)
+}
+
+// validUnknownProperties 未定义属性处理方式为空（使用全局配置）或支持的处理方式
+func validUnknownProperties(mode string) error {
+	if mode != "" && !model.UnknownPropertiesModes[mode] {
+		return badRequest("invalid unknown properties mode [" + mode + "]")
+	}
+	return nil
+}
+
+// validPolicy 处理策略为空（使用上一级的策略）或支持的策略
+func validPolicy(policy string) error {
+	if policy != "" && !model.Policies[policy] {
+		return badRequest("invalid policy [" + policy + "]")
+	}
+	return nil
+}
+
+func validFieldRequest(request *FieldRequest) error {
+	if !namePattern.MatchString(request.Field) {
+		return badRequest("invalid field name [" + request.Field + "]")
+	}
+	if !jsonPathPattern.MatchString(request.JsonPath) {
+		return badRequest("invalid json path [" + request.JsonPath + "]")
+	}
+	if !fieldTypes[request.Type] {
+		return badRequest("invalid field type [" + request.Type + "]")
+	}
+	if request.Length <= 0 {
+		return badRequest("field length must be positive")
+	}
+	if request.Type == TypeList && request.ElementType != "" && !elementTypes[request.ElementType] {
+		return badRequest("invalid list element type [" + request.ElementType + "]")
+	}
+	if request.MaxElements < 0 {
+		return badRequest("max elements can not be negative")
+	}
+	if request.Parent == request.Field {
+		return badRequest("field [" + request.Field + "] can not be the parent of itself")
+	}
+	if err := validPolicy(request.Policy); err != nil {
+		return err
+	}
+	return validConstraints(request.Constraints)
+}
+
+// validConstraints 校验约束能否编译（正则、格式等）
+func validConstraints(constraints *constraint.Constraints) error {
+	if _, err := constraints.Compile(); err != nil {
+		return badRequest("invalid constraints: " + err.Error())
+	}
+	return nil
+}
+
+// validFieldParent 子字段所属的字段必须存在且为 object 类型
+func validFieldParent(request *FieldRequest) error {
+	if request.Parent == "" {
+		return nil
+	}
+	parent, err := dao.FindFieldByName(request.Parent)
+	if err != nil {
+		if errors.Is(err, dao.ErrRecordNotFound) {
+			return badRequest("parent field [" + request.Parent + "] not exists")
+		}
+		return err
+	}
+	if parent.Type != TypeObject {
+		return badRequest("parent field [" + request.Parent + "] is not an object field")
+	}
+	return nil
+}
+
+func validEventFieldRequest(request *EventFieldRequest) error {
+	if err := validPolicy(request.Policy); err != nil {
+		return err
+	}
+	if err := validConstraints(request.Constraints); err != nil {
+		return err
+	}
+	if _, err := dao.FindEventByName(request.Event); err != nil {
+		if errors.Is(err, dao.ErrRecordNotFound) {
+			return badRequest("event [" + request.Event + "] not exists")
+		}
+		return err
+	}
+	if _, err := dao.FindFieldByName(request.Field); err != nil {
+		if errors.Is(err, dao.ErrRecordNotFound) {
+			return badRequest("field [" + request.Field + "] not exists")
+		}
+		return err
+	}
+	return nil
+}
+
+func validEnumValueRequest(request *EnumValueRequest) error {
+	if request.EnumValue == "" {
+		return badRequest("enum value can not be empty")
+	}
+	field, err := dao.FindFieldByName(request.Field)
+	if err != nil {
+		if errors.Is(err, dao.ErrRecordNotFound) {
+			return badRequest("field [" + request.Field + "] not exists")
+		}
+		return err
+	}
+	if field.Type != TypeEnum {
+		return badRequest("field [" + request.Field + "] is not an enum field")
+	}
+	return nil
+}
+
+// checkNotExists 校验唯一性，find 返回记录不存在时通过
+func checkNotExists(err error, message string) error {
+	if err == nil {
+		return conflict(message)
+	}
+	if errors.Is(err, dao.ErrRecordNotFound) {
+		return nil
+	}
+	return err
+}
+
+// ------------------ events ----------------------
+
+func listEvents(c *gin.Context) {
+	events, err := dao.FindAllEvents()
+	if err != nil {
+		writeMetadataError(c, err)
+		return
+	}
+	writeMetadataData(c, events)
+}
+
+func getEvent(c *gin.Context) {
+	event, err := findEvent(c)
+	if err != nil {
+		writeMetadataError(c, err)
+		return
+	}
+	writeMetadataData(c, event)
+}
+
+func findEvent(c *gin.Context) (*dao.DbpEvent, error) {
+	id, err := getId(c)
+	if err != nil {
+		return nil, err
+	}
+	return dao.FindEventById(id)
+}
+
+func createEvent(c *gin.Context) {
+	var request EventRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		writeMetadataError(c, badRequest(err.Error()))
+		return
+	}
+	if err := validEventRequest(&request); err != nil {
+		writeMetadataError(c, err)
+		return
+	}
+	_, err := dao.FindEventByName(request.Event)
+	if err = checkNotExists(err, "event ["+request.Event+"] already exists"); err != nil {
+		writeMetadataError(c, err)
+		return
+	}
+
+	event := &dao.DbpEvent{Event: request.Event, Description: request.Description, Policy: request.Policy,
+		UnknownProperties: request.UnknownProperties}
+	if err := dao.SaveEvent(event); err != nil {
+		writeMetadataError(c, err)
+		return
+	}
+	cache.SendEventChangeMessage()
+	writeMetadataData(c, event)
+}
+
+func updateEvent(c *gin.Context) {
+	event, err := findEvent(c)
+	if err != nil {
+		writeMetadataError(c, err)
+		return
+	}
+	var request EventRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		writeMetadataError(c, badRequest(err.Error()))
+		return
+	}
+	if request.Event != "" && request.Event != event.Event {
+		writeMetadataError(c, badRequest("event name can not be modified"))
+		return
+	}
+	if err := validPolicy(request.Policy); err != nil {
+		writeMetadataError(c, err)
+		return
+	}
+	if err := validUnknownProperties(request.UnknownProperties); err != nil {
+		writeMetadataError(c, err)
+		return
+	}
+
+	event.Description = request.Description
+	event.Policy = request.Policy
+	event.UnknownProperties = request.UnknownProperties
+	if err := dao.SaveEvent(event); err != nil {
+		writeMetadataError(c, err)
+		return
+	}
+	cache.SendEventChangeMessage()
+	writeMetadataData(c, event)
+}
+
+func deleteEvent(c *gin.Context) {
+	event, err := findEvent(c)
+	if err != nil {
+		writeMetadataError(c, err)
+		return
+	}
+	if err := dao.DeleteEvent(event); err != nil {
+		writeMetadataError(c, err)
+		return
+	}
+	cache.SendEventChangeMessage()
+	cache.SendEventFieldChangeMessage(event.Event)
+	writeMetadataData(c, nil)
+}
+
+// ------------------ fields ----------------------
+
+func listFields(c *gin.Context) {
+	fields, err := dao.FindAllFields()
+	if err != nil {
+		writeMetadataError(c, err)
+		return
+	}
+	writeMetadataData(c, fields)
+}
+
+func getField(c *gin.Context) {
+	field, err := findField(c)
+	if err != nil {
+		writeMetadataError(c, err)
+		return
+	}
+	writeMetadataData(c, field)
+}
+
+func findField(c *gin.Context) (*dao.DbpField, error) {
+	id, err := getId(c)
+	if err != nil {
+		return nil, err
+	}
+	return dao.FindFieldById(id)
+}
+
+func createField(c *gin.Context) {
+	var request FieldRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		writeMetadataError(c, badRequest(err.Error()))
+		return
+	}
+	if err := validFieldRequest(&request); err != nil {
+		writeMetadataError(c, err)
+		return
+	}
+	if err := validFieldParent(&request); err != nil {
+		writeMetadataError(c, err)
+		return
+	}
+	_, err := dao.FindFieldByName(request.Field)
+	if err = checkNotExists(err, "field ["+request.Field+"] already exists"); err != nil {
+		writeMetadataError(c, err)
+		return
+	}
+
+	field := &dao.DbpField{}
+	setField(field, &request)
+	if err := dao.SaveField(field); err != nil {
+		writeMetadataError(c, err)
+		return
+	}
+	cache.SendFieldChangeMessage()
+	writeMetadataData(c, field)
+}
+
+func updateField(c *gin.Context) {
+	field, err := findField(c)
+	if err != nil {
+		writeMetadataError(c, err)
+		return
+	}
+	var request FieldRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		writeMetadataError(c, badRequest(err.Error()))
+		return
+	}
+	if request.Field == "" {
+		request.Field = field.Field
+	}
+	if request.Field != field.Field {
+		writeMetadataError(c, badRequest("field name can not be modified"))
+		return
+	}
+	if err := validFieldRequest(&request); err != nil {
+		writeMetadataError(c, err)
+		return
+	}
+	if err := validFieldParent(&request); err != nil {
+		writeMetadataError(c, err)
+		return
+	}
+
+	setField(field, &request)
+	if err := dao.SaveField(field); err != nil {
+		writeMetadataError(c, err)
+		return
+	}
+	cache.SendFieldChangeMessage()
+	writeMetadataData(c, field)
+}
+
+func setField(field *dao.DbpField, request *FieldRequest) {
+	field.Field = request.Field
+	field.JsonPath = request.JsonPath
+	field.Type = request.Type
+	field.Length = request.Length
+	field.Name = request.Name
+	field.Nullable = request.Nullable
+	field.ElementType = request.ElementType
+	field.MaxElements = request.MaxElements
+	field.Parent = request.Parent
+	field.Constraints = request.Constraints.String()
+	field.Policy = request.Policy
+	field.Coerce = request.Coerce
+}
+
+func deleteField(c *gin.Context) {
+	field, err := findField(c)
+	if err != nil {
+		writeMetadataError(c, err)
+		return
+	}
+	events, err := dao.DeleteField(field)
+	if err != nil {
+		writeMetadataError(c, err)
+		return
+	}
+	cache.SendFieldChangeMessage()
+	cache.SendFieldValuesChangeMessage(field.Field)
+	for _, event := range events {
+		cache.SendEventFieldChangeMessage(event)
+	}
+	writeMetadataData(c, nil)
+}
+
+// ------------------ event fields ----------------------
+
+// listEventFields 查询事件字段配置，必须指定事件 ?event=xxx
+func listEventFields(c *gin.Context) {
+	event := c.Query("event")
+	if event == "" {
+		writeMetadataError(c, badRequest("query parameter [event] is required"))
+		return
+	}
+	eventFields, err := dao.FindAllEventFieldByEvent(event)
+	if err != nil {
+		writeMetadataError(c, err)
+		return
+	}
+	writeMetadataData(c, eventFields)
+}
+
+func getEventField(c *gin.Context) {
+	eventField, err := findEventField(c)
+	if err != nil {
+		writeMetadataError(c, err)
+		return
+	}
+	writeMetadataData(c, eventField)
+}
+
+func findEventField(c *gin.Context) (*dao.DbpEventField, error) {
+	id, err := getId(c)
+	if err != nil {
+		return nil, err
+	}
+	return dao.FindEventFieldById(id)
+}
+
+func createEventField(c *gin.Context) {
+	var request EventFieldRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		writeMetadataError(c, badRequest(err.Error()))
+		return
+	}
+	if err := validEventFieldRequest(&request); err != nil {
+		writeMetadataError(c, err)
+		return
+	}
+	_, err := dao.FindEventField(request.Event, request.Field)
+	if err = checkNotExists(err, "field ["+request.Field+"] of event ["+request.Event+"] already exists"); err != nil {
+		writeMetadataError(c, err)
+		return
+	}
+
+	eventField := &dao.DbpEventField{Event: request.Event, Field: request.Field, Nullable: request.Nullable,
+		Constraints: request.Constraints.String(), Policy: request.Policy}
+	if err := dao.SaveEventField(eventField); err != nil {
+		writeMetadataError(c, err)
+		return
+	}
+	cache.SendEventFieldChangeMessage(eventField.Event)
+	writeMetadataData(c, eventField)
+}
+
+// updateEventField 只允许修改是否可为空、约束和处理策略，事件、字段不能修改
+func updateEventField(c *gin.Context) {
+	eventField, err := findEventField(c)
+	if err != nil {
+		writeMetadataError(c, err)
+		return
+	}
+	var request EventFieldRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		writeMetadataError(c, badRequest(err.Error()))
+		return
+	}
+	if (request.Event != "" && request.Event != eventField.Event) || (request.Field != "" && request.Field != eventField.Field) {
+		writeMetadataError(c, badRequest("event and field of event field can not be modified"))
+		return
+	}
+	if err := validPolicy(request.Policy); err != nil {
+		writeMetadataError(c, err)
+		return
+	}
+	if err := validConstraints(request.Constraints); err != nil {
+		writeMetadataError(c, err)
+		return
+	}
+
+	eventField.Nullable = request.Nullable
+	eventField.Constraints = request.Constraints.String()
+	eventField.Policy = request.Policy
+	if err := dao.SaveEventField(eventField); err != nil {
+		writeMetadataError(c, err)
+		return
+	}
+	cache.SendEventFieldChangeMessage(eventField.Event)
+	writeMetadataData(c, eventField)
+}
+
+func deleteEventField(c *gin.Context) {
+	eventField, err := findEventField(c)
+	if err != nil {
+		writeMetadataError(c, err)
+		return
+	}
+	if err := dao.DeleteEventField(eventField); err != nil {
+		writeMetadataError(c, err)
+		return
+	}
+	cache.SendEventFieldChangeMessage(eventField.Event)
+	writeMetadataData(c, nil)
+}
+
+// ------------------ enum values ----------------------
+
+// listEnumValues 查询枚举值，必须指定字段 ?field=xxx
+func listEnumValues(c *gin.Context) {
+	field := c.Query("field")
+	if field == "" {
+		writeMetadataError(c, badRequest("query parameter [field] is required"))
+		return
+	}
+	enumValues, err := dao.FindAllEnumValuesByField(field)
+	if err != nil {
+		writeMetadataError(c, err)
+		return
+	}
+	writeMetadataData(c, enumValues)
+}
+
+func getEnumValue(c *gin.Context) {
+	enumValue, err := findEnumValue(c)
+	if err != nil {
+		writeMetadataError(c, err)
+		return
+	}
+	writeMetadataData(c, enumValue)
+}
+
+func findEnumValue(c *gin.Context) (*dao.DbpFieldEnumValue, error) {
+	id, err := getId(c)
+	if err != nil {
+		return nil, err
+	}
+	return dao.FindEnumValueById(id)
+}
+
+func createEnumValue(c *gin.Context) {
+	var request EnumValueRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		writeMetadataError(c, badRequest(err.Error()))
+		return
+	}
+	if err := validEnumValueRequest(&request); err != nil {
+		writeMetadataError(c, err)
+		return
+	}
+	_, err := dao.FindEnumValue(request.Field, request.EnumValue)
+	if err = checkNotExists(err, "value ["+request.EnumValue+"] of field ["+request.Field+"] already exists"); err != nil {
+		writeMetadataError(c, err)
+		return
+	}
+
+	// 删除过的枚举值恢复原记录，避免违反唯一索引
+	enumValue, err := dao.FindDeletedEnumValue(request.Field, request.EnumValue)
+	if err == nil {
+		enumValue.ValueName = request.ValueName
+		err = dao.RestoreEnumValue(enumValue)
+	} else if errors.Is(err, dao.ErrRecordNotFound) {
+		enumValue = &dao.DbpFieldEnumValue{Field: request.Field, EnumValue: request.EnumValue, ValueName: request.ValueName}
+		err = dao.SaveEnumValue(enumValue)
+	}
+	if err != nil {
+		writeMetadataError(c, err)
+		return
+	}
+	cache.SendFieldValuesChangeMessage(enumValue.Field)
+	writeMetadataData(c, enumValue)
+}
+
+// updateEnumValue 只允许修改枚举值名称，字段、枚举值不能修改
+func updateEnumValue(c *gin.Context) {
+	enumValue, err := findEnumValue(c)
+	if err != nil {
+		writeMetadataError(c, err)
+		return
+	}
+	var request EnumValueRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		writeMetadataError(c, badRequest(err.Error()))
+		return
+	}
+	if (request.Field != "" && request.Field != enumValue.Field) || (request.EnumValue != "" && request.EnumValue != enumValue.EnumValue) {
+		writeMetadataError(c, badRequest("field and value of enum value can not be modified"))
+		return
+	}
+
+	enumValue.ValueName = request.ValueName
+	if err := dao.SaveEnumValue(enumValue); err != nil {
+		writeMetadataError(c, err)
+		return
+	}
+	cache.SendFieldValuesChangeMessage(enumValue.Field)
+	writeMetadataData(c, enumValue)
+}
+
+func deleteEnumValue(c *gin.Context) {
+	enumValue, err := findEnumValue(c)
+	if err != nil {
+		writeMetadataError(c, err)
+		return
+	}
+	if err := dao.DeleteEnumValue(enumValue); err != nil {
+		writeMetadataError(c, err)
+		return
+	}
+	cache.SendFieldValuesChangeMessage(enumValue.Field)
+	writeMetadataData(c, nil)
+}
+
+// ------------------ discovered fields ----------------------
+
+// listDiscoveredFields 查询未定义属性发现记录，可按事件过滤 ?event=xxx
+func listDiscoveredFields(c *gin.Context) {
+	discoveredFields, err := dao.FindAllDiscoveredFields(c.Query("event"))
+	if err != nil {
+		writeMetadataError(c, err)
+		return
+	}
+	writeMetadataData(c, discoveredFields)
+}
+
+// deleteDiscoveredField 审核完成（已补充字段定义或确认忽略）后删除发现记录（物理删除），之后再次上报时重新记录
+func deleteDiscoveredField(c *gin.Context) {
+	id, err := getId(c)
+	if err != nil {
+		writeMetadataError(c, err)
+		return
+	}
+	discoveredField, err := dao.FindDiscoveredFieldById(id)
+	if err != nil {
+		writeMetadataError(c, err)
+		return
+	}
+	if err := dao.DeleteDiscoveredField(discoveredField); err != nil {
+		writeMetadataError(c, err)
+		return
+	}
+	writeMetadataData(c, nil)
+}
+
+// ------------------ discovered events ----------------------
+
+// listDiscoveredEvents 查询未定义事件发现记录，按上报次数倒序
+func listDiscoveredEvents(c *gin.Context) {
+	discoveredEvents, err := dao.FindAllDiscoveredEvents()
+	if err != nil {
+		writeMetadataError(c, err)
+		return
+	}
+	writeMetadataData(c, discoveredEvents)
+}
+
+func findDiscoveredEvent(c *gin.Context) (*dao.DbpDiscoveredEvent, error) {
+	id, err := getId(c)
+	if err != nil {
+		return nil, err
+	}
+	return dao.FindDiscoveredEventById(id)
+}
+
+// deleteDiscoveredEvent 确认忽略的未定义事件删除发现记录（物理删除），之后再次上报时重新记录
+func deleteDiscoveredEvent(c *gin.Context) {
+	discoveredEvent, err := findDiscoveredEvent(c)
+	if err != nil {
+		writeMetadataError(c, err)
+		return
+	}
+	if err := dao.DeleteDiscoveredEvent(discoveredEvent); err != nil {
+		writeMetadataError(c, err)
+		return
+	}
+	writeMetadataData(c, nil)
+}
+
+// promoteDiscoveredEvent 未定义事件转为事件定义，并删除发现记录
+// 请求体可选，可指定事件的 Description、Policy、UnknownProperties，事件名固定为发现记录的事件名
+func promoteDiscoveredEvent(c *gin.Context) {
+	discoveredEvent, err := findDiscoveredEvent(c)
+	if err != nil {
+		writeMetadataError(c, err)
+		return
+	}
+	var request EventRequest
+	if err := c.ShouldBindJSON(&request); err != nil && !errors.Is(err, io.EOF) {
+		writeMetadataError(c, badRequest(err.Error()))
+		return
+	}
+	if request.Event != "" && request.Event != discoveredEvent.Event {
+		writeMetadataError(c, badRequest("event name can not be modified"))
+		return
+	}
+	request.Event = discoveredEvent.Event
+	if err := validEventRequest(&request); err != nil {
+		writeMetadataError(c, err)
+		return
+	}
+	_, err = dao.FindEventByName(request.Event)
+	if err = checkNotExists(err, "event ["+request.Event+"] already exists"); err != nil {
+		writeMetadataError(c, err)
+		return
+	}
+
+	event := &dao.DbpEvent{Event: request.Event, Description: request.Description, Policy: request.Policy,
+		UnknownProperties: request.UnknownProperties}
+	if err := dao.PromoteDiscoveredEvent(discoveredEvent, event); err != nil {
+		writeMetadataError(c, err)
+		return
+	}
+	cache.SendEventChangeMessage()
+	writeMetadataData(c, event)
+}
+
+// ------------------ versions ----------------------
+
+// getVersions 查询本节点元数据本地缓存的版本号及 redis 中的版本号，Local 与 Remote 不一致说明本节点缓存未刷新
+func getVersions(c *gin.Context) {
+	hostname, _ := os.Hostname()
+	writeMetadataData(c, gin.H{
+		"node":     hostname,
+		"versions": cache.Versions(),
+	})
+}
+
+// ------------------ schema bundle ----------------------
+
+// exportSchemaBundle 导出 schema bundle 文件，format：yaml（默认）、json
+func exportSchemaBundle(c *gin.Context) {
+	format := c.DefaultQuery("format", schemaFormatYaml)
+	bundle, err := exportSchema()
+	if err != nil {
+		writeMetadataError(c, err)
+		return
+	}
+	content, err := marshalBundle(bundle, format)
+	if err != nil {
+		writeMetadataError(c, err)
+		return
+	}
+	contentType := "application/x-yaml"
+	if format == schemaFormatJson {
+		contentType = "application/json"
+	}
+	c.Header("Content-Disposition", "attachment; filename=schema."+format)
+	c.Data(http.StatusOK, contentType, content)
+}
+
+// importSchemaBundle 导入 schema bundle，请求体为 bundle 文件内容，Content-Type 为 application/json 时按 json 解析，否则按 yaml 解析
+// 默认 dry-run 只返回差异，dryRun=false 时应用
+func importSchemaBundle(c *gin.Context) {
+	dryRun, err := strconv.ParseBool(c.DefaultQuery("dryRun", "true"))
+	if err != nil {
+		writeMetadataError(c, badRequest("invalid dryRun ["+c.Query("dryRun")+"]"))
+		return
+	}
+	content, err := c.GetRawData()
+	if err != nil {
+		writeMetadataError(c, badRequest(err.Error()))
+		return
+	}
+	format := schemaFormatYaml
+	if c.ContentType() == gin.MIMEJSON {
+		format = schemaFormatJson
+	}
+	bundle, err := parseBundle(content, format)
+	if err != nil {
+		writeMetadataError(c, err)
+		return
+	}
+	diff, err := importSchema(bundle, !dryRun)
+	if err != nil {
+		writeMetadataError(c, err)
+		return
+	}
+	writeMetadataData(c, gin.H{
+		"dryRun": dryRun,
+		"diff":   diff,
+	})
+}
+
+// ------------------ response ----------------------
+
+func getId(c *gin.Context) (uint, error) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return 0, badRequest("invalid id [" + c.Param("id") + "]")
+	}
+	return uint(id), nil
+}
+
+func writeMetadataData(c *gin.Context, data interface{}) {
+	c.JSON(http.StatusOK, gin.H{
+		"errno": "0",
+		"data":  data,
+	})
+}
+
+// writeMetadataError 参数错误返回 400、记录不存在返回 404、已存在返回 409，其他（数据库）错误返回 500
+func writeMetadataError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	var metaErr *metadataError
+	if errors.As(err, &metaErr) {
+		status = metaErr.status
+	} else if errors.Is(err, dao.ErrRecordNotFound) {
+		status = http.StatusNotFound
+	} else {
+		logger.Logger.Error("failed to access metadata. caused by: " + err.Error())
+	}
+	c.JSON(status, gin.H{
+		"errno": "1",
+		"err":   err.Error(),
+	})
+}
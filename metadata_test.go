@@ -0,0 +1,28 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestValidFieldRequest(t *testing.T) {
+	valid := FieldRequest{Field: "page_id", JsonPath: "properties.page_id", Type: TypeString, Length: 128}
+	if err := validFieldRequest(&valid); err != nil {
+		t.Fatalf("field should be valid, got %v", err)
+	}
+
+	cases := map[string]FieldRequest{
+		"invalid name":      {Field: "1page", JsonPath: "page", Type: TypeString, Length: 1},
+		"invalid json path": {Field: "page_id", JsonPath: "properties..page_id", Type: TypeString, Length: 1},
+		"invalid type":      {Field: "page_id", JsonPath: "properties.page_id", Type: "varchar", Length: 1},
+		"invalid length":    {Field: "page_id", JsonPath: "properties.page_id", Type: TypeString, Length: 0},
+	}
+	for name, request := range cases {
+		err := validFieldRequest(&request)
+		var metaErr *metadataError
+		if !errors.As(err, &metaErr) || metaErr.status != http.StatusBadRequest {
+			t.Errorf("%s: should be bad request, got %v", name, err)
+		}
+	}
+}
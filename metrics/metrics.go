@@ -0,0 +1,198 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"liangck.xyz/data-service/sensors-log-acceptor/spool"
+)
+
+// -------------------- Metrics. Implemented by Prometheus(https://github.com/prometheus/client_golang)
+// 通过 /metrics 暴露，指标统一以 sensors_log_acceptor_ 为前缀
+//------------------------
+
+const namespace = "sensors_log_acceptor"
+
+// 上报方式
+const TransportPost = "post"     // ajax、android、ios post 上报
+const TransportBeacon = "beacon" // js sdk sendBeacon 上报（Content-Type: text/plain）
+const TransportImage = "image"   // js sdk 图片（GET）上报
+
+// UnknownLib 无法解析出 sdk 类型时的 lib 标签值
+const UnknownLib = "unknown"
+
+// OtherLib 不在 knownLibs 中的 sdk 类型的 lib 标签值
+const OtherLib = "other"
+
+// 神策 sdk 上报的 lib.$lib，lib 标签只使用这些值，避免客户端上报任意值导致指标序列无限增长
+var knownLibs = map[string]bool{
+	"js":           true,
+	"Android":      true,
+	"iOS":          true,
+	"MiniProgram":  true,
+	"QuickApp":     true,
+	"Flutter":      true,
+	"react-native": true,
+	"Java":         true,
+	"python":       true,
+	"PHP":          true,
+	"Go":           true,
+	"Node":         true,
+	"CSharp":       true,
+}
+
+// LibLabel 转换 sdk 类型为 lib 标签值：未知为 unknown，不在 knownLibs 中为 other
+func LibLabel(lib string) string {
+	if lib == "" || lib == UnknownLib {
+		return UnknownLib
+	}
+	if knownLibs[lib] {
+		return lib
+	}
+	return OtherLib
+}
+
+// RequestsTotal 接收的上报请求数，按上报方式和 sdk 类型（lib.$lib，不在已知 sdk 类型中的为 other，见 LibLabel）统计
+var RequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "requests_total",
+	Help:      "Received tracking requests by transport and SDK lib.",
+}, []string{"transport", "lib"})
+
+// EventsAcceptedTotal 校验通过的事件数
+var EventsAcceptedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "events_accepted_total",
+	Help:      "Events accepted after validation by event name.",
+}, []string{"event"})
+
+// DataAcceptedTotal 校验通过的用户属性（profile_*）、物品（item_*）数据数，按数据类型统计
+var DataAcceptedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "data_accepted_total",
+	Help:      "Profile and item data accepted after validation by data type.",
+}, []string{"type"})
+
+// EventsRejectedTotal 校验失败的事件数，按错误类型和字段统计（未定义属性的错误字段为空，属性名不作为标签）
+var EventsRejectedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "events_rejected_total",
+	Help:      "Validation rejections by error type and field.",
+}, []string{"err_type", "field"})
+
+// GzipDecodeFailuresTotal data_list base64、gzip 解码失败数
+var GzipDecodeFailuresTotal = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "gzip_decode_failures_total",
+	Help:      "Failures decoding base64/gzip data_list payloads.",
+})
+
+// KafkaWriteDuration kafka 消息发送耗时（从提交到收到发送结果）
+var KafkaWriteDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: namespace,
+	Name:      "kafka_write_duration_seconds",
+	Help:      "Latency of Kafka writes by topic.",
+	Buckets:   prometheus.ExponentialBuckets(0.005, 2, 12),
+}, []string{"topic"})
+
+// KafkaWriteErrorsTotal kafka 消息发送失败数
+var KafkaWriteErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "kafka_write_errors_total",
+	Help:      "Failed Kafka message writes by topic.",
+}, []string{"topic"})
+
+// LoggerKafkaDroppedTotal 输出到 kafka 的日志因缓冲区满或发送失败被丢弃的条数
+var LoggerKafkaDroppedTotal = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "logger_kafka_dropped_total",
+	Help:      "Service log entries dropped by the Kafka log output.",
+})
+
+// CacheRefreshTotal 元数据缓存刷新次数
+var CacheRefreshTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "cache_refresh_total",
+	Help:      "Metadata cache refreshes by table.",
+}, []string{"table"})
+
+// CacheLastRefreshTimestamp 元数据缓存最后刷新时间
+var CacheLastRefreshTimestamp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "cache_last_refresh_timestamp_seconds",
+	Help:      "Unix timestamp of the last metadata cache refresh by table.",
+}, []string{"table"})
+
+// IdentityLinksTotal track_signup 关联匿名 id 与登录 id 的次数，按关联结果统计
+var IdentityLinksTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "identity_links_total",
+	Help:      "Anonymous to login id links by result.",
+}, []string{"result"})
+
+// IdentityLookupErrorsTotal 查询 id 关联关系失败数（redis 不可用等），失败时 user_key 使用 distinct_id
+var IdentityLookupErrorsTotal = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "identity_lookup_errors_total",
+	Help:      "Failed identity lookups falling back to distinct_id.",
+})
+
+// CacheRefreshErrorsTotal 元数据加载失败次数，失败时继续使用之前的元数据快照
+var CacheRefreshErrorsTotal = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "cache_refresh_errors_total",
+	Help:      "Failed metadata reloads; the previous snapshot is kept.",
+})
+
+// CacheVersion 元数据本地缓存对应的版本号
+var CacheVersion = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "cache_version",
+	Help:      "Metadata version of the local cache by table.",
+}, []string{"table"})
+
+// FieldsRepairedTotal 按处理策略修正（丢弃、置空、截断、类型转换）后继续发送的字段数，按策略、错误类型和字段统计
+var FieldsRepairedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "fields_repaired_total",
+	Help:      "Invalid fields repaired by validation policy instead of rejecting the event.",
+}, []string{"policy", "err_type", "field"})
+
+// FieldsCoercedTotal 开启类型转换的字段转换次数，按字段、上报类型、字段类型和 sdk 类型（LibLabel）统计；
+// 具体的 sdk 版本不作为标签（取值不受控），见管理接口 /admin/coercedVersions
+var FieldsCoercedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "fields_coerced_total",
+	Help:      "Field values coerced to the defined type by field, source type, target type and SDK lib.",
+}, []string{"field", "from", "to", "lib"})
+
+// UnknownPropertiesTotal 行为事件上报的未定义属性数，按事件和处理方式统计
+var UnknownPropertiesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "unknown_properties_total",
+	Help:      "Undefined properties reported with track events by event and unknown property mode.",
+}, []string{"event", "mode"})
+
+// CacheRefreshed 记录元数据缓存刷新
+func CacheRefreshed(table string) {
+	CacheRefreshTotal.WithLabelValues(table).Inc()
+	CacheLastRefreshTimestamp.WithLabelValues(table).SetToCurrentTime()
+}
+
+// RegisterSpoolStats 注册本地落盘队列积压指标
+func RegisterSpoolStats(stats func() spool.Stats) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "spool_backlog_records",
+		Help:      "Messages waiting in the on-disk spool.",
+	}, func() float64 { return float64(stats().Records) })
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "spool_backlog_bytes",
+		Help:      "Bytes waiting in the on-disk spool.",
+	}, func() float64 { return float64(stats().Bytes) })
+	promauto.NewCounterFunc(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "spool_dropped_total",
+		Help:      "Messages dropped because the spool reached its size cap.",
+	}, func() float64 { return float64(stats().Dropped) })
+}
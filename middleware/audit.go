@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"time"
+)
+
+// Audit 管理接口审计日志，记录调用方（AdminAuth 认证通过的名称）、调用的接口及结果
+// 认证失败的请求同样记录，调用方为空
+func Audit(logger *zap.Logger) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		start := time.Now()
+		ctx.Next()
+		logger.Info(
+			"admin request",
+			zap.String("caller", ctx.GetString(AdminCallerKey)),
+			zap.String("method", ctx.Request.Method),
+			zap.String("uri", ctx.Request.URL.RequestURI()),
+			zap.Int("status", ctx.Writer.Status()),
+			zap.String("ip", ctx.ClientIP()),
+			zap.Duration("cost", time.Since(start)),
+		)
+	}
+}
@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"encoding/hex"
+	"github.com/gin-gonic/gin"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newAuthEngine() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(AdminAuth(&AuthConf{
+		Tokens:   map[string]string{"ops": "ops-token"},
+		HmacKeys: map[string]string{"console": "console-secret"},
+	}))
+	r.POST("/admin/eventFieldChange", func(c *gin.Context) {
+		body, _ := ioutil.ReadAll(c.Request.Body)
+		c.String(http.StatusOK, c.GetString(AdminCallerKey)+":"+string(body))
+	})
+	return r
+}
+
+func TestAdminAuthBearer(t *testing.T) {
+	r := newAuthEngine()
+	for token, expected := range map[string]int{"ops-token": http.StatusOK, "wrong": http.StatusUnauthorized, "": http.StatusUnauthorized} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/admin/eventFieldChange", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		r.ServeHTTP(w, req)
+		if w.Code != expected {
+			t.Errorf("token [%s] should get %d, got %d", token, expected, w.Code)
+		}
+	}
+}
+
+func TestAdminAuthHmac(t *testing.T) {
+	r := newAuthEngine()
+	uri := "/admin/eventFieldChange?event=page_view"
+	body := `{"event":"page_view"}`
+	newRequest := func(secret string, timestamp time.Time) *http.Request {
+		ts := strconv.FormatInt(timestamp.Unix(), 10)
+		req := httptest.NewRequest(http.MethodPost, uri, strings.NewReader(body))
+		req.Header.Set(HeaderAdminKey, "console")
+		req.Header.Set(HeaderAdminTimestamp, ts)
+		req.Header.Set(HeaderAdminSignature, hex.EncodeToString(SignAdminRequest(secret, http.MethodPost, uri, ts, []byte(body))))
+		return req
+	}
+
+	now := time.Now()
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, newRequest("console-secret", now))
+	if w.Code != http.StatusOK || w.Body.String() != "console:"+body {
+		t.Fatalf("signed request should pass with body kept, got %d %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, newRequest("console-secret", now))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("replayed signature should be rejected, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, newRequest("wrong-secret", time.Now()))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("wrong signature should be rejected, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, newRequest("console-secret", time.Now().Add(-time.Hour)))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expired timestamp should be rejected, got %d", w.Code)
+	}
+}
@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strings"
+)
+
+// Cors 跨域处理，允许其他域名下的 js sdk 直接上报数据
+// allowOrigins 为允许的 Origin 列表（逗号分隔）：
+// 配置的 Origin 回写请求的 Origin 并允许携带 cookie（js sdk 的 ajax 上报 withCredentials）；
+// 为空或包含 * 时允许所有 Origin，未配置的 Origin 返回 *，不允许携带 cookie，避免任意站点携带用户 cookie 访问。
+func Cors(allowOrigins string) gin.HandlerFunc {
+	allowAll := strings.TrimSpace(allowOrigins) == ""
+	originSet := make(map[string]bool)
+	for _, origin := range strings.Split(allowOrigins, ",") {
+		origin = strings.TrimSpace(origin)
+		if origin == "*" {
+			allowAll = true
+			continue
+		}
+		if origin != "" {
+			originSet[origin] = true
+		}
+	}
+
+	return func(ctx *gin.Context) {
+		origin := ctx.GetHeader("Origin")
+		if origin != "" && (allowAll || originSet[origin]) {
+			header := ctx.Writer.Header()
+			if originSet[origin] {
+				header.Set("Access-Control-Allow-Origin", origin)
+				header.Set("Access-Control-Allow-Credentials", "true")
+			} else {
+				header.Set("Access-Control-Allow-Origin", "*")
+			}
+			header.Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+			header.Set("Access-Control-Allow-Headers", "Content-Type")
+			header.Set("Access-Control-Max-Age", "86400")
+			header.Add("Vary", "Origin")
+		}
+
+		// 预检请求直接返回
+		if ctx.Request.Method == http.MethodOptions {
+			ctx.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+		ctx.Next()
+	}
+}
@@ -1,5 +1,7 @@
 package model
 
+import "strings"
+
 // ErrType 错误类型
 type ErrType int
 
@@ -12,14 +14,62 @@ const (
 	EventUndefined                   // Event 不存在(元数据中未定义)
 	ParsedFailed                     // 解析失败
 	InvalidFormat                    // 无效的数据格式
+	CrcMismatch                      // crc 校验失败（数据被截断或篡改）
+	DataTypeUndefined                // 数据类型（type）不支持
+	FieldUndefined                   // 字段未定义（用户属性未在元数据中定义）
+	ItemTypeUndefined                // 物品类型未定义
+	ValueOutOfRange                  // 数值超出范围（约束 min、max）
+	ValueTooShort                    // 字符串长度不足（约束 minLength）
+	PatternMismatch                  // 字符串不匹配正则（约束 regex）
+	FormatMismatch                   // 字符串格式不正确（约束 format：url、email、uuid、ipv4）
+	PropertyUndefined                // 行为事件上报了未定义的属性（未定义属性处理方式为 strict）
 )
 
+var errTypeNames = []string{
+	None:              "None",
+	TypeMisMatch:      "TypeMisMatch",
+	ValueTooLong:      "ValueTooLong",
+	ValueCannotBeNull: "ValueCannotBeNull",
+	ValueNotExist:     "ValueNotExist",
+	EventUndefined:    "EventUndefined",
+	ParsedFailed:      "ParsedFailed",
+	InvalidFormat:     "InvalidFormat",
+	CrcMismatch:       "CrcMismatch",
+	DataTypeUndefined: "DataTypeUndefined",
+	FieldUndefined:    "FieldUndefined",
+	ItemTypeUndefined: "ItemTypeUndefined",
+	ValueOutOfRange:   "ValueOutOfRange",
+	ValueTooShort:     "ValueTooShort",
+	PatternMismatch:   "PatternMismatch",
+	FormatMismatch:    "FormatMismatch",
+	PropertyUndefined: "PropertyUndefined",
+}
+
+// String 错误类型名称
+func (t ErrType) String() string {
+	if t >= 0 && int(t) < len(errTypeNames) {
+		return errTypeNames[t]
+	}
+	return "Unknown"
+}
+
+// ParseErrType 根据名称（不区分大小写）获取错误类型
+func ParseErrType(name string) (ErrType, bool) {
+	for errType, errTypeName := range errTypeNames {
+		if strings.EqualFold(errTypeName, name) {
+			return ErrType(errType), true
+		}
+	}
+	return None, false
+}
+
 // Log 埋点日志
 type Log struct {
 	Gzip     string
 	DataList string
 	Data     string
 	Crc      string
+	Ext      string
 }
 
 // ValidResult 验证结果
@@ -32,10 +82,18 @@ type ValidResult struct {
 	ErrType ErrType
 }
 
+// FieldError 字段校验错误
+type FieldError struct {
+	Field   string
+	ErrType ErrType
+	Message string
+}
+
 // ReportError 上报异常
 type ReportError struct {
 	Err     string
 	ErrType ErrType
+	Errors  []FieldError `json:",omitempty"` // 所有字段校验错误（collectAll 模式）
 	Data    string
 	Time    int64  // 序列化后的时间
 	ID      string // id UUID
@@ -0,0 +1,31 @@
+package model
+
+// 字段校验失败处理策略，说明见 main 包 policy.go
+const PolicyReject = "reject"
+const PolicyDropField = "drop_field"
+const PolicyNullField = "null_field"
+const PolicyTruncate = "truncate"
+const PolicyCoerce = "coerce"
+
+// Policies 支持的处理策略
+var Policies = map[string]bool{
+	PolicyReject:    true,
+	PolicyDropField: true,
+	PolicyNullField: true,
+	PolicyTruncate:  true,
+	PolicyCoerce:    true,
+}
+
+// 未定义属性处理方式，说明见 main 包 property.go
+const UnknownPropertiesIgnore = "ignore"
+const UnknownPropertiesStrict = "strict"
+const UnknownPropertiesPassthrough = "passthrough"
+const UnknownPropertiesDiscover = "discover"
+
+// UnknownPropertiesModes 支持的未定义属性处理方式
+var UnknownPropertiesModes = map[string]bool{
+	UnknownPropertiesIgnore:      true,
+	UnknownPropertiesStrict:      true,
+	UnknownPropertiesPassthrough: true,
+	UnknownPropertiesDiscover:    true,
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"github.com/Jeffail/gabs"
+	"liangck.xyz/data-service/sensors-log-acceptor/cache"
+	"liangck.xyz/data-service/sensors-log-acceptor/dao"
+	"liangck.xyz/data-service/sensors-log-acceptor/metrics"
+	. "liangck.xyz/data-service/sensors-log-acceptor/model"
+	"unicode/utf8"
+)
+
+// 字段校验失败处理策略（软校验）
+// 字段校验失败时按策略修正字段后继续发送数据，保证分析数据的连续性，修正记录在数据的 _quality 中：
+//	reject：丢弃整条数据，发送异常信息（默认）
+//	drop_field：丢弃该字段
+//	null_field：字段置为 null
+//	truncate：超长的字符串截断为字段长度，超过最大元素个数的数组截断，其他错误丢弃整条数据
+//	coerce：类型不匹配时尝试转换类型（如 "3" -> 3），无法转换时丢弃整条数据
+// 策略优先级：事件字段配置 > 字段定义 > 事件 > 全局配置（validation.policy）。
+// 事件不存在、必需的 id 缺失等非字段错误不适用策略，始终丢弃整条数据。
+
+// 策略常量及支持的策略（Policies）定义在 model 包，元数据快照构建时同样需要校验。
+
+// QualityField 数据中记录字段修正的 key
+const QualityField = "_quality"
+
+// QualityIssue 字段修正记录
+type QualityIssue struct {
+	Field   string `json:"field"`
+	ErrType string `json:"errType"`
+	Policy  string `json:"policy"`
+	Message string `json:"message"`
+}
+
+// fieldPolicy 字段的处理策略，字段（事件字段）未配置时使用 defaultPolicy
+func fieldPolicy(field dao.DbpField, defaultPolicy string) string {
+	if field.Policy != "" {
+		return field.Policy
+	}
+	return defaultPolicy
+}
+
+// eventPolicy 事件的默认处理策略，事件未配置时使用全局配置
+func eventPolicy(snapshot *cache.MetadataSnapshot, event string) string {
+	if policy := snapshot.EventPolicy(event); policy != "" {
+		return policy
+	}
+	return handlerConf.Policy
+}
+
+// applyPolicy 按策略修正校验失败的字段，修正成功时记录到 data 的 _quality 中并返回 true
+func applyPolicy(snapshot *cache.MetadataSnapshot, jsonParsed *gabs.Container, data *map[string]interface{}, field dao.DbpField,
+	validResult *ValidResult, policy string) bool {
+	switch policy {
+	case PolicyDropField:
+		delete(*data, field.Field)
+	case PolicyNullField:
+		(*data)[field.Field] = nil
+	case PolicyTruncate:
+		if validResult.ErrType != ValueTooLong {
+			return false
+		}
+		truncated, ok := truncateValue(jsonParsed.Path(field.JsonPath).Data(), field)
+		if !ok || !revalidField(snapshot, data, field, truncated) {
+			return false
+		}
+	case PolicyCoerce:
+		if validResult.ErrType != TypeMisMatch {
+			return false
+		}
+		coerced, ok := coerceValue(jsonParsed.Path(field.JsonPath).Data(), field)
+		if !ok || !revalidField(snapshot, data, field, coerced) {
+			return false
+		}
+	default:
+		return false
+	}
+
+	issues, _ := (*data)[QualityField].([]QualityIssue)
+	(*data)[QualityField] = append(issues, QualityIssue{Field: field.Field, ErrType: validResult.ErrType.String(), Policy: policy, Message: validResult.Err})
+	metrics.FieldsRepairedTotal.WithLabelValues(policy, validResult.ErrType.String(), field.Field).Inc()
+	return true
+}
+
+// revalidField 使用修正后的值重新校验字段
+func revalidField(snapshot *cache.MetadataSnapshot, data *map[string]interface{}, field dao.DbpField, value interface{}) bool {
+	container := gabs.New()
+	if _, err := container.SetP(value, field.JsonPath); err != nil {
+		return false
+	}
+	return validField(snapshot, container, data, field).OK
+}
+
+// truncateValue 截断超长的字符串、数组，其他类型无法截断
+func truncateValue(value interface{}, field dao.DbpField) (interface{}, bool) {
+	switch v := value.(type) {
+	case string:
+		return truncateString(v, field.Length), true
+	case []interface{}:
+		if field.MaxElements > 0 && len(v) > field.MaxElements {
+			v = v[:field.MaxElements]
+		}
+		list := make([]interface{}, 0, len(v))
+		for _, element := range v {
+			if str, ok := element.(string); ok {
+				element = truncateString(str, field.Length)
+			}
+			list = append(list, element)
+		}
+		return list, true
+	}
+	return nil, false
+}
+
+func truncateString(value string, length int) string {
+	if utf8.RuneCountInString(value) <= length {
+		return value
+	}
+	return string([]rune(value)[:length])
+}
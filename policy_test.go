@@ -0,0 +1,49 @@
+package main
+
+import (
+	"github.com/Jeffail/gabs"
+	"liangck.xyz/data-service/sensors-log-acceptor/cache"
+	"liangck.xyz/data-service/sensors-log-acceptor/dao"
+	. "liangck.xyz/data-service/sensors-log-acceptor/model"
+	"testing"
+)
+
+func TestValidFieldsWithPolicy(t *testing.T) {
+	handlerConf = &HandlerConf{ValidationMode: ValidationModeCollectAll}
+	snapshot := cache.NewMetadataSnapshot(&cache.Metadata{}, nil)
+	jsonParsed, _ := gabs.ParseJSON([]byte(`{"properties":{"current_page_url":"https://liangck.xyz/very/long","material_position":"3","app_name":1,"os":true}}`))
+	fields := []dao.DbpField{
+		{Field: "current_page_url", JsonPath: "properties.current_page_url", Type: TypeString, Length: 12, Policy: PolicyTruncate},
+		{Field: "material_position", JsonPath: "properties.material_position", Type: TypeInt, Length: 8, Policy: PolicyCoerce},
+		{Field: "app_name", JsonPath: "properties.app_name", Type: TypeBool, Length: 8, Policy: PolicyDropField},
+		{Field: "os", JsonPath: "properties.os", Type: TypeFloat, Length: 8},
+	}
+
+	data := map[string]interface{}{}
+	fieldErrors := validFields(snapshot, jsonParsed, &data, fields, PolicyNullField)
+	if len(fieldErrors) != 0 {
+		t.Fatalf("all fields should be repaired, got %v", fieldErrors)
+	}
+	if data["current_page_url"] != "https://lian" || data["material_position"] != 3 {
+		t.Errorf("unexpected repaired values %v", data)
+	}
+	if _, ok := data["app_name"]; ok {
+		t.Errorf("app_name should be dropped, got %v", data["app_name"])
+	}
+	if value, ok := data["os"]; !ok || value != nil {
+		t.Errorf("os should be null by default policy, got %v", value)
+	}
+	issues := data[QualityField].([]QualityIssue)
+	if len(issues) != 4 || issues[0].Policy != PolicyTruncate || issues[0].ErrType != ValueTooLong.String() {
+		t.Errorf("unexpected quality issues %v", issues)
+	}
+
+	// 无法修正时记为校验错误
+	data = map[string]interface{}{}
+	fieldErrors = validFields(snapshot, jsonParsed, &data, []dao.DbpField{
+		{Field: "os", JsonPath: "properties.os", Type: TypeFloat, Length: 8, Policy: PolicyTruncate},
+	}, PolicyReject)
+	if len(fieldErrors) != 1 || fieldErrors[0].ErrType != TypeMisMatch {
+		t.Errorf("os should be rejected, got %v", fieldErrors)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"github.com/Jeffail/gabs"
+	"liangck.xyz/data-service/sensors-log-acceptor/cache"
+	"liangck.xyz/data-service/sensors-log-acceptor/dao"
+	"liangck.xyz/data-service/sensors-log-acceptor/kafka"
+	"liangck.xyz/data-service/sensors-log-acceptor/metrics"
+	. "liangck.xyz/data-service/sensors-log-acceptor/model"
+	"sort"
+	"strings"
+)
+
+const PropertiesJsonPath = "properties"
+
+// validProfileData 校验用户属性（profile_*）数据并发送
+// 用户属性为增量更新，只校验上报了的属性，上报的属性必须在 dbp_profile_fields 中定义。
+// profile_append、profile_unset 的属性值为追加的列表、true，不按字段类型校验，原样输出
+func validProfileData(snapshot *cache.MetadataSnapshot, jsonParsed *gabs.Container, dataType string, data []byte) (bool, error) {
+	distinctId, ok := jsonParsed.Path(DistinctId).Data().(string)
+	if !ok || distinctId == "" {
+		return reject(ValueCannotBeNull, DistinctId, "field ["+DistinctId+"] can not be null", data)
+	}
+	validDataMap := map[string]interface{}{
+		DataType:   dataType,
+		DistinctId: distinctId,
+	}
+
+	fields := snapshot.ProfileFields()
+	fieldErrors := validUndefinedProperties(jsonParsed, fields)
+	if len(fieldErrors) == 0 && dataType != DataTypeProfileDelete {
+		for _, field := range fields {
+			if dataType == DataTypeProfileAppend || dataType == DataTypeProfileUnset {
+				if value := jsonParsed.Path(field.JsonPath).Data(); value != nil {
+					validDataMap[field.Field] = value
+				}
+				continue
+			}
+			// 增量更新，未上报的属性不做非空校验
+			field.Nullable = true
+			fieldErrors = append(fieldErrors, validFields(snapshot, jsonParsed, &validDataMap, []dao.DbpField{field}, handlerConf.Policy)...)
+			if len(fieldErrors) > 0 && handlerConf.ValidationMode != ValidationModeCollectAll {
+				break
+			}
+		}
+	}
+	if len(fieldErrors) > 0 {
+		return rejectFieldErrors(fieldErrors, data)
+	}
+
+	fillUserKey(jsonParsed, &validDataMap)
+	FillReceiveTimeField(&validDataMap)
+	if err := kafka.WriteProfileMsg(&validDataMap); err != nil {
+		return reject(InvalidFormat, DataType, "failed to marshal valid data: "+err.Error(), data)
+	}
+	metrics.DataAcceptedTotal.WithLabelValues(dataType).Inc()
+	return true, nil
+}
+
+// validUndefinedProperties 校验上报的属性是否都已定义，神策预置属性（$ 开头）不校验
+func validUndefinedProperties(jsonParsed *gabs.Container, fields []dao.DbpField) []FieldError {
+	var fieldErrors []FieldError
+	for _, property := range undefinedProperties(jsonParsed, fields) {
+		fieldErrors = append(fieldErrors, FieldError{Field: property, ErrType: FieldUndefined, Message: "field [" + property + "] is not defined"})
+		if handlerConf.ValidationMode != ValidationModeCollectAll {
+			break
+		}
+	}
+	return fieldErrors
+}
+
+// undefinedProperties 上报的未定义属性（按名称排序），神策预置属性（$ 开头）除外
+func undefinedProperties(jsonParsed *gabs.Container, fields []dao.DbpField) []string {
+	definedPaths := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		definedPaths[field.JsonPath] = true
+	}
+
+	properties, _ := jsonParsed.Path(PropertiesJsonPath).ChildrenMap()
+	var names []string
+	for property := range properties {
+		if strings.HasPrefix(property, "$") || definedPaths[PropertiesJsonPath+"."+property] {
+			continue
+		}
+		names = append(names, property)
+	}
+	sort.Strings(names)
+	return names
+}
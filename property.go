@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/Jeffail/gabs"
+	"liangck.xyz/data-service/sensors-log-acceptor/cache"
+	"liangck.xyz/data-service/sensors-log-acceptor/dao"
+	"liangck.xyz/data-service/sensors-log-acceptor/discovery"
+	"liangck.xyz/data-service/sensors-log-acceptor/metrics"
+	. "liangck.xyz/data-service/sensors-log-acceptor/model"
+	"math"
+)
+
+// 未定义属性处理方式
+// 行为事件上报了未定义的属性（properties 下不对应事件任何字段的属性，神策预置属性 $ 开头除外）时：
+//	ignore：忽略未定义属性，只输出定义了的字段（默认）
+//	strict：丢弃整条数据，发送异常信息（PropertyUndefined）
+//	passthrough：未定义属性放入 extra 字段（json 字符串）随数据输出
+//	discover：忽略未定义属性，记录属性名、推断的类型和示例值（dbp_discovered_fields），供审核后补充字段定义，
+//	          属性名不合法（无法定义为字段）的不记录；
+//	          元数据来源为文件时不记录，等同于 ignore
+// 事件配置（dbp_events.unknown_properties）优先，未配置时使用全局配置（validation.unknownProperties）。
+
+// 处理方式常量及支持的处理方式（UnknownPropertiesModes）定义在 model 包，元数据快照构建时同样需要校验。
+
+// ExtraField passthrough 时输出未定义属性的字段
+const ExtraField = "extra"
+
+// unknownPropertiesMode 事件的未定义属性处理方式，事件未配置时使用全局配置
+func unknownPropertiesMode(snapshot *cache.MetadataSnapshot, event string) string {
+	if mode := snapshot.EventUnknownProperties(event); mode != "" {
+		return mode
+	}
+	return handlerConf.UnknownProperties
+}
+
+// handleUnknownProperties 按事件的处理方式处理未定义属性，strict 时返回未定义属性的校验错误
+func handleUnknownProperties(snapshot *cache.MetadataSnapshot, jsonParsed *gabs.Container, event string, fields []dao.DbpField,
+	data *map[string]interface{}) []FieldError {
+	properties := undefinedProperties(jsonParsed, fields)
+	if len(properties) == 0 {
+		return nil
+	}
+	mode := unknownPropertiesMode(snapshot, event)
+	metrics.UnknownPropertiesTotal.WithLabelValues(event, mode).Add(float64(len(properties)))
+
+	values, _ := jsonParsed.Path(PropertiesJsonPath).ChildrenMap()
+	switch mode {
+	case UnknownPropertiesStrict:
+		var fieldErrors []FieldError
+		for _, property := range properties {
+			fieldErrors = append(fieldErrors, FieldError{Field: property, ErrType: PropertyUndefined,
+				Message: "property [" + property + "] is not defined for event [" + event + "]"})
+			if handlerConf.ValidationMode != ValidationModeCollectAll {
+				break
+			}
+		}
+		return fieldErrors
+	case UnknownPropertiesPassthrough:
+		extra := make(map[string]interface{}, len(properties))
+		for _, property := range properties {
+			extra[property] = values[property].Data()
+		}
+		if bytes, err := json.Marshal(extra); err == nil {
+			(*data)[ExtraField] = string(bytes)
+		}
+	case UnknownPropertiesDiscover:
+		for _, property := range properties {
+			// 属性名不合法（无法定义为字段）的不记录
+			if !namePattern.MatchString(property) {
+				continue
+			}
+			value := values[property].Data()
+			discovery.RecordField(event, property, inferFieldType(value), value)
+		}
+	}
+	return nil
+}
+
+// inferFieldType 根据上报值推断字段类型：符合 datetime 格式的字符串为 datetime，整数为 long，
+// 小数为 float，数组为 list，对象为 object
+func inferFieldType(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		if _, ok := parseTime(v, handlerConf.DatetimeLayouts); ok {
+			return TypeDatetime
+		}
+		return TypeString
+	case float64:
+		if v == math.Trunc(v) {
+			return TypeLong
+		}
+		return TypeFloat
+	case bool:
+		return TypeBool
+	case []interface{}:
+		return TypeList
+	case map[string]interface{}:
+		return TypeObject
+	}
+	return jsonTypeName(value)
+}
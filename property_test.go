@@ -0,0 +1,80 @@
+package main
+
+import (
+	"github.com/Jeffail/gabs"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"liangck.xyz/data-service/sensors-log-acceptor/cache"
+	"liangck.xyz/data-service/sensors-log-acceptor/dao"
+	"liangck.xyz/data-service/sensors-log-acceptor/metrics"
+	. "liangck.xyz/data-service/sensors-log-acceptor/model"
+	"strings"
+	"testing"
+)
+
+func TestHandleUnknownProperties(t *testing.T) {
+	handlerConf = &HandlerConf{ValidationMode: ValidationModeCollectAll, UnknownProperties: UnknownPropertiesIgnore,
+		DatetimeLayouts: defaultDatetimeLayouts}
+	snapshot := cache.NewMetadataSnapshot(&cache.Metadata{Events: []dao.DbpEvent{
+		{Event: "strict_view", UnknownProperties: UnknownPropertiesStrict},
+		{Event: "passthrough_view", UnknownProperties: UnknownPropertiesPassthrough},
+	}}, nil)
+	jsonParsed, _ := gabs.ParseJSON([]byte(`{"properties":{"$os":"iOS","page_id":"p1","color":"red","size":3}}`))
+	fields := []dao.DbpField{{Field: "page_id", JsonPath: "properties.page_id", Type: TypeString, Length: 8}}
+
+	data := map[string]interface{}{}
+	if fieldErrors := handleUnknownProperties(snapshot, jsonParsed, "view", fields, &data); len(fieldErrors) != 0 || len(data) != 0 {
+		t.Errorf("unknown properties should be ignored by default, got %v, %v", fieldErrors, data)
+	}
+
+	fieldErrors := handleUnknownProperties(snapshot, jsonParsed, "strict_view", fields, &data)
+	if len(fieldErrors) != 2 || fieldErrors[0].Field != "color" || fieldErrors[0].ErrType != PropertyUndefined {
+		t.Errorf("unexpected strict errors %v", fieldErrors)
+	}
+
+	if fieldErrors := handleUnknownProperties(snapshot, jsonParsed, "passthrough_view", fields, &data); len(fieldErrors) != 0 {
+		t.Errorf("passthrough should not reject, got %v", fieldErrors)
+	}
+	if data[ExtraField] != `{"color":"red","size":3}` {
+		t.Errorf("unexpected extra %v", data[ExtraField])
+	}
+}
+
+func TestCountRejectedUndefinedProperties(t *testing.T) {
+	countRejectedFields([]FieldError{
+		{Field: "random_a", ErrType: PropertyUndefined, Message: "property [random_a] is not defined for event [view]"},
+		{Field: "random_b", ErrType: PropertyUndefined, Message: "property [random_b] is not defined for event [view]"},
+	})
+	if count := testutil.ToFloat64(metrics.EventsRejectedTotal.WithLabelValues(PropertyUndefined.String(), "")); count != 2 {
+		t.Errorf("undefined properties should be counted without field label, got %v", count)
+	}
+	// 未定义的属性名不作为指标标签
+	families, _ := prometheus.DefaultGatherer.Gather()
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if strings.HasPrefix(label.GetValue(), "random_") {
+					t.Errorf("property name should not be a label of %s", family.GetName())
+				}
+			}
+		}
+	}
+}
+
+func TestInferFieldType(t *testing.T) {
+	handlerConf = &HandlerConf{DatetimeLayouts: defaultDatetimeLayouts}
+	cases := map[string]interface{}{
+		TypeString:   "red",
+		TypeDatetime: "2022-01-02 03:04:05",
+		TypeLong:     float64(3),
+		TypeFloat:    1.5,
+		TypeBool:     true,
+		TypeList:     []interface{}{"a"},
+		TypeObject:   map[string]interface{}{},
+	}
+	for expected, value := range cases {
+		if fieldType := inferFieldType(value); fieldType != expected {
+			t.Errorf("expected %s for %v, got %s", expected, value, fieldType)
+		}
+	}
+}
@@ -1,29 +1,39 @@
 package main
 
 import (
+	"encoding/base64"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
 	"io/ioutil"
 	"liangck.xyz/data-service/sensors-log-acceptor/cache"
 	"liangck.xyz/data-service/sensors-log-acceptor/configer"
 	"liangck.xyz/data-service/sensors-log-acceptor/logger"
+	"liangck.xyz/data-service/sensors-log-acceptor/metrics"
 	"liangck.xyz/data-service/sensors-log-acceptor/middleware"
 	"net/http"
+	"strings"
+	"time"
 )
 
+// 1x1 透明 gif，用于 js sdk 的图片（GET）上报方式
+var pixelGif, _ = base64.StdEncoding.DecodeString("R0lGODlhAQABAIAAAAAAAP///yH5BAEAAAAALAAAAAABAAEAAAIBRAA7")
+
 // handle request
+// post 方式（ajax、sendBeacon，sendBeacon 的 Content-Type 为 text/plain）请求体格式相同，均为 data=xxx&gzip=xxx
 // 1.read request body
 // 2.valid logger by meta data
 // 3.send result to kafka
 func handle(context *gin.Context) {
 	jsonData, err := ioutil.ReadAll(context.Request.Body)
-	logger.Logger.Info("origin jsonData: " + string(jsonData))
+	logger.PayloadLogger.Debug("origin jsonData", zap.ByteString("data", jsonData))
 	if err != nil {
 		context.JSON(http.StatusOK, gin.H{
 			"errno": "1",
 			"err":   err.Error(),
 		})
 	} else {
-		ok, err2 := Handle(jsonData)
+		ok, err2 := Handle(jsonData, getPostTransport(context))
 		if !ok && err2 != nil {
 			context.JSON(http.StatusOK, gin.H{
 				"errno": "1",
@@ -37,23 +47,109 @@ func handle(context *gin.Context) {
 	}
 }
 
-func InitRouter(config *configer.Config) {
+// getPostTransport sendBeacon 上报的 Content-Type 为 text/plain，其他 post 上报为表单格式
+func getPostTransport(context *gin.Context) string {
+	if strings.HasPrefix(context.ContentType(), "text/plain") {
+		return metrics.TransportBeacon
+	}
+	return metrics.TransportPost
+}
+
+// handleImage js sdk 图片方式上报，数据在 query string 中（data=xxx&ext=xxx）
+// 无论校验结果如何都返回 1x1 gif，sdk 不关心响应内容
+func handleImage(context *gin.Context) {
+	logger.PayloadLogger.Debug("origin query", zap.String("query", context.Request.URL.RawQuery))
+	ok, err := Handle([]byte(context.Request.URL.RawQuery), metrics.TransportImage)
+	if !ok && err != nil {
+		logger.Logger.Info("handle image request failed: " + err.Error())
+	}
+	context.Header("Cache-Control", "no-cache, no-store, must-revalidate")
+	context.Data(http.StatusOK, "image/gif", pixelGif)
+}
+
+// InitRouter 初始化路由并启动 http 服务，返回 server 用于优雅退出
+// 配置了管理接口地址（admin.address）时，管理接口在单独的地址上提供服务，不对外暴露
+func InitRouter(config *configer.Config) []*http.Server {
+	r := newEngine()
+	sa := r.Group("/sa.go", middleware.Cors(config.CorsAllowOrigins))
+	sa.POST("", handle)
+	sa.GET("", handleImage)
+	sa.OPTIONS("", func(c *gin.Context) {})
+
+	//r.GET("/getConfig", func(c *gin.Context) {
+	//	key := c.Query("key")
+	//	var value = ""
+	//	if key != "" {
+	//		value = configer.GetString(key)
+	//	}
+	//
+	//	c.JSON(http.StatusOK, gin.H{
+	//		"value": value,
+	//	})
+	//})
+
+	// admin
+	adminEngine := r
+	if config.AdminAddress != "" && config.AdminAddress != config.ServiceAddress {
+		adminEngine = newEngine()
+	}
+	admin := adminEngine.Group("/admin",
+		middleware.Audit(logger.AuditLogger),
+		middleware.AdminAuth(&middleware.AuthConf{
+			Tokens:   config.AdminTokens,
+			HmacKeys: config.AdminHmacKeys,
+			MaxSkew:  time.Duration(config.AdminHmacMaxSkew) * time.Second,
+		}),
+	)
+	admin.Any("/logLevel", gin.WrapH(logger.Level))
+	admin.GET("/coercedVersions", listCoercedVersions)
+	// 元数据来源为文件时，元数据通过修改文件变更，不提供变更通知和管理接口
+	if cache.Versioned() {
+		registerChangeRoutes(admin)
+		registerMetadataRoutes(admin.Group("/metadata"))
+	}
+
+	// prometheus metrics
+	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
+
+	// health check
+	r.Any("/ping", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"error":    "",
+			"errno":    "0",
+			"dataType": "OBJECT",
+			"data":     "",
+		})
+	})
+
+	servers := []*http.Server{startServer(config.ServiceAddress, r)}
+	if adminEngine != r {
+		servers = append(servers, startServer(config.AdminAddress, adminEngine))
+	}
+	return servers
+}
+
+func newEngine() *gin.Engine {
 	r := gin.Default()
 	r.Use(middleware.GinLogger(logger.Logger), middleware.GinRecovery(logger.Logger, true))
-	r.POST("/sa.go", handle)
-	r.POST("/fieldChange", func(c *gin.Context) {
+	return r
+}
+
+// registerChangeRoutes 元数据变更通知接口，发布变更消息，各节点收到后刷新本地缓存
+func registerChangeRoutes(group *gin.RouterGroup) {
+	group.POST("/fieldChange", func(c *gin.Context) {
 		cache.SendFieldChangeMessage()
 		c.JSON(http.StatusOK, gin.H{
 			"errno": "0",
 		})
 	})
-	r.POST("/eventChange", func(c *gin.Context) {
+	group.POST("/eventChange", func(c *gin.Context) {
 		cache.SendEventChangeMessage()
 		c.JSON(http.StatusOK, gin.H{
 			"errno": "0",
 		})
 	})
-	r.POST("/eventFieldChange", func(c *gin.Context) {
+	group.POST("/eventFieldChange", func(c *gin.Context) {
 		event := c.Query("event")
 		if event != "" {
 			cache.SendEventFieldChangeMessage(event)
@@ -62,7 +158,7 @@ func InitRouter(config *configer.Config) {
 			"errno": "0",
 		})
 	})
-	r.POST("/fieldValuesChange", func(c *gin.Context) {
+	group.POST("/fieldValuesChange", func(c *gin.Context) {
 		field := c.Query("field")
 		if field != "" {
 			cache.SendFieldValuesChangeMessage(field)
@@ -71,28 +167,30 @@ func InitRouter(config *configer.Config) {
 			"errno": "0",
 		})
 	})
-
-	//r.GET("/getConfig", func(c *gin.Context) {
-	//	key := c.Query("key")
-	//	var value = ""
-	//	if key != "" {
-	//		value = configer.GetString(key)
-	//	}
-	//
-	//	c.JSON(http.StatusOK, gin.H{
-	//		"value": value,
-	//	})
-	//})
-
-	// health check
-	r.Any("/ping", func(c *gin.Context) {
+	group.POST("/profileFieldChange", func(c *gin.Context) {
+		cache.SendProfileFieldChangeMessage()
 		c.JSON(http.StatusOK, gin.H{
-			"error":    "",
-			"errno":    "0",
-			"dataType": "OBJECT",
-			"data":     "",
+			"errno": "0",
+		})
+	})
+	group.POST("/itemFieldChange", func(c *gin.Context) {
+		cache.SendItemFieldChangeMessage()
+		c.JSON(http.StatusOK, gin.H{
+			"errno": "0",
 		})
 	})
+}
 
-	r.Run(config.ServiceAddress)
+func startServer(address string, handler http.Handler) *http.Server {
+	srv := &http.Server{
+		Addr:    address,
+		Handler: handler,
+	}
+	go func() {
+		logger.Logger.Info("listening and serving HTTP on " + address)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Logger.Fatal("failed to start http server: " + err.Error())
+		}
+	}()
+	return srv
 }
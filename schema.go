@@ -0,0 +1,664 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"liangck.xyz/data-service/sensors-log-acceptor/cache"
+	"liangck.xyz/data-service/sensors-log-acceptor/configer"
+	"liangck.xyz/data-service/sensors-log-acceptor/constraint"
+	"liangck.xyz/data-service/sensors-log-acceptor/dao"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// schema bundle：所有元数据表（dbp_*）导出的单个文件，用于在环境之间迁移元数据（如测试环境 -> 生产环境）
+// 格式与元数据文件（cache.MetadataFile）相同，导出的 bundle 也可以直接作为 metadata.source = file 的元数据文件使用。
+// 导入时以 bundle 为准：bundle 中新增的记录新建、修改的记录更新、不存在的记录软删除；
+// 默认只计算差异（dry-run），确认后再应用，应用在一个事务中完成，成功后发布变更消息。
+// 记录以业务 key 匹配（事件名、字段名、事件+字段、字段+枚举值、物品类型+字段），不使用 id，不同环境的 id 可以不同。
+
+// TableDiff 一张元数据表的差异，记录的 key 按字典序排列
+type TableDiff struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+	Changed []string `json:"changed"`
+}
+
+func (d *TableDiff) empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+func (d *TableDiff) sort() {
+	sort.Strings(d.Added)
+	sort.Strings(d.Removed)
+	sort.Strings(d.Changed)
+}
+
+// SchemaDiff 当前元数据与 schema bundle 的差异
+type SchemaDiff struct {
+	Events        TableDiff `json:"events"`
+	Fields        TableDiff `json:"fields"`
+	EventFields   TableDiff `json:"eventFields"`
+	EnumValues    TableDiff `json:"enumValues"`
+	ProfileFields TableDiff `json:"profileFields"`
+	ItemFields    TableDiff `json:"itemFields"`
+}
+
+// Empty 是否没有差异
+func (d *SchemaDiff) Empty() bool {
+	return d.Events.empty() && d.Fields.empty() && d.EventFields.empty() &&
+		d.EnumValues.empty() && d.ProfileFields.empty() && d.ItemFields.empty()
+}
+
+// String 差异的文本格式，每行一条记录：+ 新增、- 删除、~ 修改
+func (d *SchemaDiff) String() string {
+	var b strings.Builder
+	tables := []struct {
+		name string
+		diff *TableDiff
+	}{
+		{cache.TableEvents, &d.Events},
+		{cache.TableFields, &d.Fields},
+		{cache.TableEventFields, &d.EventFields},
+		{cache.TableFieldEnumValues, &d.EnumValues},
+		{cache.TableProfileFields, &d.ProfileFields},
+		{cache.TableItemFields, &d.ItemFields},
+	}
+	for _, table := range tables {
+		for _, key := range table.diff.Added {
+			b.WriteString("+ " + table.name + " " + key + "\n")
+		}
+		for _, key := range table.diff.Removed {
+			b.WriteString("- " + table.name + " " + key + "\n")
+		}
+		for _, key := range table.diff.Changed {
+			b.WriteString("~ " + table.name + " " + key + "\n")
+		}
+	}
+	if b.Len() == 0 {
+		return "no changes\n"
+	}
+	return b.String()
+}
+
+// ------------------ command ----------------------
+
+// runSchemaCommand 执行 schema 子命令，返回进程退出码
+//
+//	schema export [-o file] [-format yaml|json]    导出到文件，未指定文件时输出到标准输出
+//	schema import -f file [-apply]                  默认只输出差异（dry-run），-apply 时应用
+//
+// 文件格式根据扩展名判断，.json 为 json，其他为 yaml
+func runSchemaCommand(config *configer.Config, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: schema export|import [options]")
+		return 2
+	}
+	dao.InitDb(config)
+	defer dao.Close()
+
+	var err error
+	switch args[0] {
+	case "export":
+		err = runSchemaExport(args[1:])
+	case "import":
+		cache.InitRedis(config)
+		defer cache.Close()
+		err = runSchemaImport(args[1:])
+	default:
+		fmt.Fprintln(os.Stderr, "unknown schema command: "+args[0])
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	return 0
+}
+
+func runSchemaExport(args []string) error {
+	flags := flag.NewFlagSet("schema export", flag.ContinueOnError)
+	output := flags.String("o", "", "output file, default is stdout")
+	format := flags.String("format", "", "bundle format: yaml or json, default is determined by output file extension")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *format == "" {
+		*format = fileFormat(*output)
+	}
+
+	bundle, err := exportSchema()
+	if err != nil {
+		return err
+	}
+	content, err := marshalBundle(bundle, *format)
+	if err != nil {
+		return err
+	}
+	if *output == "" {
+		_, err = os.Stdout.Write(content)
+		return err
+	}
+	return ioutil.WriteFile(*output, content, 0644)
+}
+
+func runSchemaImport(args []string) error {
+	flags := flag.NewFlagSet("schema import", flag.ContinueOnError)
+	file := flags.String("f", "", "schema bundle file")
+	apply := flags.Bool("apply", false, "apply changes, default only prints the diff (dry-run)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *file == "" {
+		return fmt.Errorf("schema bundle file (-f) is required")
+	}
+
+	content, err := ioutil.ReadFile(*file)
+	if err != nil {
+		return err
+	}
+	bundle, err := parseBundle(content, fileFormat(*file))
+	if err != nil {
+		return err
+	}
+	diff, err := importSchema(bundle, *apply)
+	if err != nil {
+		return err
+	}
+	fmt.Print(diff.String())
+	if *apply {
+		fmt.Println("applied.")
+	} else if !diff.Empty() {
+		fmt.Println("dry-run, run with -apply to apply the changes.")
+	}
+	return nil
+}
+
+// fileFormat 根据文件扩展名判断 bundle 格式
+func fileFormat(path string) string {
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		return schemaFormatJson
+	}
+	return schemaFormatYaml
+}
+
+// ------------------ bundle ----------------------
+
+const schemaFormatYaml = "yaml"
+const schemaFormatJson = "json"
+
+// marshalBundle 按格式（yaml、json）序列化 schema bundle
+func marshalBundle(bundle *cache.MetadataFile, format string) ([]byte, error) {
+	if format == schemaFormatJson {
+		return json.MarshalIndent(bundle, "", "  ")
+	}
+	return yaml.Marshal(bundle)
+}
+
+// parseBundle 解析 schema bundle，不认识的 key 报错，避免拼写错误的字段被静默忽略
+func parseBundle(content []byte, format string) (*cache.MetadataFile, error) {
+	var bundle cache.MetadataFile
+	var err error
+	if format == schemaFormatJson {
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&bundle)
+	} else {
+		err = yaml.UnmarshalStrict(content, &bundle)
+	}
+	if err != nil {
+		return nil, badRequest("invalid schema bundle: " + err.Error())
+	}
+	return &bundle, nil
+}
+
+// exportSchema 从数据库导出 schema bundle
+func exportSchema() (*cache.MetadataFile, error) {
+	metadata, err := cache.LoadMetadata()
+	if err != nil {
+		return nil, err
+	}
+	bundle := cache.NewMetadataFile(metadata)
+	bundle.FormatVersion = cache.MetadataFormatVersion
+	if envFlag := flag.Lookup("e"); envFlag != nil {
+		bundle.Env = envFlag.Value.String()
+	}
+	bundle.ExportedAt = time.Now().Format(time.RFC3339)
+	return bundle, nil
+}
+
+// importSchema 计算当前元数据与 schema bundle 的差异，apply 为 true 且有差异时应用到数据库并发布变更消息
+func importSchema(bundle *cache.MetadataFile, apply bool) (*SchemaDiff, error) {
+	if err := validBundle(bundle); err != nil {
+		return nil, err
+	}
+	current, err := cache.LoadMetadata()
+	if err != nil {
+		return nil, err
+	}
+	deletedEnumValues, err := dao.FindDeletedEnumValues()
+	if err != nil {
+		return nil, err
+	}
+	diff, changes := diffSchema(current, bundle.ToMetadata(), *deletedEnumValues)
+	if !apply || diff.Empty() {
+		return diff, nil
+	}
+	if err := dao.ApplyMetadataChanges(changes); err != nil {
+		return nil, err
+	}
+	publishSchemaChange(diff)
+	return diff, nil
+}
+
+// publishSchemaChange 为有差异的元数据表发布变更消息
+func publishSchemaChange(diff *SchemaDiff) {
+	if !diff.Events.empty() {
+		cache.SendEventChangeMessage()
+	}
+	if !diff.Fields.empty() {
+		cache.SendFieldChangeMessage()
+	}
+	if !diff.EventFields.empty() {
+		cache.SendEventFieldChangeMessage("schema import")
+	}
+	if !diff.EnumValues.empty() {
+		cache.SendFieldValuesChangeMessage("schema import")
+	}
+	if !diff.ProfileFields.empty() {
+		cache.SendProfileFieldChangeMessage()
+	}
+	if !diff.ItemFields.empty() {
+		cache.SendItemFieldChangeMessage()
+	}
+}
+
+// ------------------ validation ----------------------
+
+// validBundle 校验 schema bundle：格式版本、名称和字段定义、key 唯一、事件字段和枚举值引用的事件与字段存在
+func validBundle(bundle *cache.MetadataFile) error {
+	if bundle.FormatVersion > cache.MetadataFormatVersion {
+		return badRequest("unsupported schema bundle format version " + strconv.Itoa(bundle.FormatVersion))
+	}
+
+	events := make(map[string]bool, len(bundle.Events))
+	for _, event := range bundle.Events {
+		if err := validEventRequest(&EventRequest{Event: event.Event, Policy: event.Policy, UnknownProperties: event.UnknownProperties}); err != nil {
+			return err
+		}
+		if events[event.Event] {
+			return badRequest("duplicate event [" + event.Event + "]")
+		}
+		events[event.Event] = true
+	}
+
+	fields := make(map[string]string, len(bundle.Fields))
+	for _, field := range bundle.Fields {
+		if err := validBundleField(field); err != nil {
+			return err
+		}
+		if _, ok := fields[field.Field]; ok {
+			return badRequest("duplicate field [" + field.Field + "]")
+		}
+		fields[field.Field] = field.Type
+	}
+	if err := validBundleParents(bundle.Fields); err != nil {
+		return err
+	}
+
+	eventFields := make(map[string]bool, len(bundle.EventFields))
+	for _, eventField := range bundle.EventFields {
+		if !events[eventField.Event] {
+			return badRequest("event [" + eventField.Event + "] of event field not exists")
+		}
+		if _, ok := fields[eventField.Field]; !ok {
+			return badRequest("field [" + eventField.Field + "] of event field not exists")
+		}
+		if err := validPolicy(eventField.Policy); err != nil {
+			return err
+		}
+		if err := validConstraints(eventField.Constraints); err != nil {
+			return err
+		}
+		key := eventFieldKey(eventField.Event, eventField.Field)
+		if eventFields[key] {
+			return badRequest("duplicate event field [" + key + "]")
+		}
+		eventFields[key] = true
+	}
+
+	enumValues := make(map[string]bool, len(bundle.EnumValues))
+	for _, enumValue := range bundle.EnumValues {
+		if enumValue.EnumValue == "" {
+			return badRequest("enum value of field [" + enumValue.Field + "] can not be empty")
+		}
+		if fields[enumValue.Field] != TypeEnum {
+			return badRequest("field [" + enumValue.Field + "] of enum value not exists or is not an enum field")
+		}
+		key := enumValueKey(enumValue.Field, enumValue.EnumValue)
+		if enumValues[key] {
+			return badRequest("duplicate enum value [" + key + "]")
+		}
+		enumValues[key] = true
+	}
+
+	profileFields := make(map[string]bool, len(bundle.ProfileFields))
+	for _, field := range bundle.ProfileFields {
+		if err := validBundleField(field); err != nil {
+			return err
+		}
+		if field.Constraints != nil || field.Policy != "" || field.Coerce {
+			return badRequest("constraints, policy and coerce of profile field [" + field.Field + "] is not supported")
+		}
+		if profileFields[field.Field] {
+			return badRequest("duplicate profile field [" + field.Field + "]")
+		}
+		profileFields[field.Field] = true
+	}
+	if err := validBundleParents(bundle.ProfileFields); err != nil {
+		return err
+	}
+
+	itemFields := make(map[string]bool, len(bundle.ItemFields))
+	for _, field := range bundle.ItemFields {
+		if !namePattern.MatchString(field.ItemType) {
+			return badRequest("invalid item type [" + field.ItemType + "]")
+		}
+		if err := validBundleField(field.FileField); err != nil {
+			return err
+		}
+		if field.Constraints != nil || field.Policy != "" || field.Coerce {
+			return badRequest("constraints, policy and coerce of item field [" + field.Field + "] is not supported")
+		}
+		key := itemFieldKey(field.ItemType, field.Field)
+		if itemFields[key] {
+			return badRequest("duplicate item field [" + key + "]")
+		}
+		itemFields[key] = true
+	}
+	itemTypeFields := make(map[string][]cache.FileField)
+	for _, field := range bundle.ItemFields {
+		itemTypeFields[field.ItemType] = append(itemTypeFields[field.ItemType], field.FileField)
+	}
+	for _, fields := range itemTypeFields {
+		if err := validBundleParents(fields); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validBundleParents 子字段所属的字段必须在同一组字段中定义且为 object 类型
+func validBundleParents(fields []cache.FileField) error {
+	types := make(map[string]string, len(fields))
+	for _, field := range fields {
+		types[field.Field] = field.Type
+	}
+	for _, field := range fields {
+		if field.Parent != "" && types[field.Parent] != TypeObject {
+			return badRequest("parent field [" + field.Parent + "] of field [" + field.Field + "] not exists or is not an object field")
+		}
+	}
+	return nil
+}
+
+func validBundleField(field cache.FileField) error {
+	return validFieldRequest(&FieldRequest{
+		Field:       field.Field,
+		JsonPath:    field.JsonPath,
+		Type:        field.Type,
+		Length:      field.Length,
+		Name:        field.Name,
+		Nullable:    field.Nullable,
+		ElementType: field.ElementType,
+		MaxElements: field.MaxElements,
+		Parent:      field.Parent,
+		Constraints: field.Constraints,
+		Policy:      field.Policy,
+		Coerce:      field.Coerce,
+	})
+}
+
+// ------------------ diff ----------------------
+
+func eventFieldKey(event string, field string) string {
+	return event + "." + field
+}
+
+func enumValueKey(field string, value string) string {
+	return field + "=" + value
+}
+
+func itemFieldKey(itemType string, field string) string {
+	return itemType + "." + field
+}
+
+// diffSchema 计算当前元数据（current，来自数据库，带 id）与目标元数据（target，来自 bundle）的差异及需要应用的变更
+// 修改的记录在当前记录（保留 id、创建时间）上更新内容
+func diffSchema(current *cache.Metadata, target *cache.Metadata, deletedEnumValues []dao.DbpFieldEnumValue) (*SchemaDiff, *dao.MetadataChanges) {
+	diff := &SchemaDiff{}
+	changes := &dao.MetadataChanges{}
+	diffEvents(current.Events, target.Events, &diff.Events, changes)
+	diffFields(current.Fields, target.Fields, &diff.Fields, changes)
+	diffEventFields(current.EventFields, target.EventFields, &diff.EventFields, changes)
+	diffEnumValues(current.EnumValues, target.EnumValues, deletedEnumValues, &diff.EnumValues, changes)
+	diffProfileFields(current.ProfileFields, target.ProfileFields, &diff.ProfileFields, changes)
+	diffItemFields(current.ItemFields, target.ItemFields, &diff.ItemFields, changes)
+	return diff, changes
+}
+
+func diffEvents(current []dao.DbpEvent, target []dao.DbpEvent, diff *TableDiff, changes *dao.MetadataChanges) {
+	currentMap := make(map[string]dao.DbpEvent, len(current))
+	for _, event := range current {
+		currentMap[event.Event] = event
+	}
+	targetKeys := make(map[string]bool, len(target))
+	for _, event := range target {
+		targetKeys[event.Event] = true
+		old, ok := currentMap[event.Event]
+		if !ok {
+			diff.Added = append(diff.Added, event.Event)
+			changes.Save = append(changes.Save, &dao.DbpEvent{Event: event.Event, Description: event.Description, Policy: event.Policy,
+				UnknownProperties: event.UnknownProperties})
+		} else if old.Description != event.Description || old.Policy != event.Policy || old.UnknownProperties != event.UnknownProperties {
+			diff.Changed = append(diff.Changed, event.Event)
+			old.Description, old.Policy, old.UnknownProperties = event.Description, event.Policy, event.UnknownProperties
+			changes.Save = append(changes.Save, &old)
+		}
+	}
+	for _, event := range current {
+		if !targetKeys[event.Event] {
+			event := event
+			diff.Removed = append(diff.Removed, event.Event)
+			changes.Delete = append(changes.Delete, &event)
+		}
+	}
+	diff.sort()
+}
+
+func diffFields(current []dao.DbpField, target []dao.DbpField, diff *TableDiff, changes *dao.MetadataChanges) {
+	currentMap := make(map[string]dao.DbpField, len(current))
+	for _, field := range current {
+		currentMap[field.Field] = field
+	}
+	targetKeys := make(map[string]bool, len(target))
+	for _, field := range target {
+		targetKeys[field.Field] = true
+		old, ok := currentMap[field.Field]
+		if !ok {
+			diff.Added = append(diff.Added, field.Field)
+			changes.Save = append(changes.Save, &dao.DbpField{Field: field.Field, JsonPath: field.JsonPath, Type: field.Type,
+				Length: field.Length, Name: field.Name, Nullable: field.Nullable,
+				ElementType: field.ElementType, MaxElements: field.MaxElements, Parent: field.Parent, Constraints: field.Constraints,
+				Policy: field.Policy, Coerce: field.Coerce})
+		} else if !sameField(old, field) {
+			diff.Changed = append(diff.Changed, field.Field)
+			old.JsonPath, old.Type, old.Length, old.Name, old.Nullable = field.JsonPath, field.Type, field.Length, field.Name, field.Nullable
+			old.ElementType, old.MaxElements, old.Parent = field.ElementType, field.MaxElements, field.Parent
+			old.Constraints, old.Policy, old.Coerce = field.Constraints, field.Policy, field.Coerce
+			changes.Save = append(changes.Save, &old)
+		}
+	}
+	for _, field := range current {
+		if !targetKeys[field.Field] {
+			field := field
+			diff.Removed = append(diff.Removed, field.Field)
+			changes.Delete = append(changes.Delete, &field)
+		}
+	}
+	diff.sort()
+}
+
+func diffEventFields(current []dao.DbpEventField, target []dao.DbpEventField, diff *TableDiff, changes *dao.MetadataChanges) {
+	currentMap := make(map[string]dao.DbpEventField, len(current))
+	for _, eventField := range current {
+		currentMap[eventFieldKey(eventField.Event, eventField.Field)] = eventField
+	}
+	targetKeys := make(map[string]bool, len(target))
+	for _, eventField := range target {
+		key := eventFieldKey(eventField.Event, eventField.Field)
+		targetKeys[key] = true
+		old, ok := currentMap[key]
+		if !ok {
+			diff.Added = append(diff.Added, key)
+			changes.Save = append(changes.Save, &dao.DbpEventField{Event: eventField.Event, Field: eventField.Field, Nullable: eventField.Nullable,
+				Constraints: eventField.Constraints, Policy: eventField.Policy})
+		} else if old.Nullable != eventField.Nullable || old.Policy != eventField.Policy ||
+			constraint.Normalize(old.Constraints) != constraint.Normalize(eventField.Constraints) {
+			diff.Changed = append(diff.Changed, key)
+			old.Nullable, old.Constraints, old.Policy = eventField.Nullable, eventField.Constraints, eventField.Policy
+			changes.Save = append(changes.Save, &old)
+		}
+	}
+	for _, eventField := range current {
+		key := eventFieldKey(eventField.Event, eventField.Field)
+		if !targetKeys[key] {
+			eventField := eventField
+			diff.Removed = append(diff.Removed, key)
+			changes.Delete = append(changes.Delete, &eventField)
+		}
+	}
+	diff.sort()
+}
+
+// diffEnumValues 枚举值差异，新增的枚举值之前删除过（deleted 中存在）时恢复原记录，
+// 唯一索引 uidx_field_value 不包含 deleted_at，不能新增相同的记录
+func diffEnumValues(current []dao.DbpFieldEnumValue, target []dao.DbpFieldEnumValue, deleted []dao.DbpFieldEnumValue, diff *TableDiff,
+	changes *dao.MetadataChanges) {
+	currentMap := make(map[string]dao.DbpFieldEnumValue, len(current))
+	for _, enumValue := range current {
+		currentMap[enumValueKey(enumValue.Field, enumValue.EnumValue)] = enumValue
+	}
+	deletedMap := make(map[string]dao.DbpFieldEnumValue, len(deleted))
+	for _, enumValue := range deleted {
+		deletedMap[enumValueKey(enumValue.Field, enumValue.EnumValue)] = enumValue
+	}
+	targetKeys := make(map[string]bool, len(target))
+	for _, enumValue := range target {
+		key := enumValueKey(enumValue.Field, enumValue.EnumValue)
+		targetKeys[key] = true
+		old, ok := currentMap[key]
+		if restored, isDeleted := deletedMap[key]; !ok && isDeleted {
+			diff.Added = append(diff.Added, key+" (restore deleted)")
+			restored.DeletedAt.Valid = false
+			restored.ValueName = enumValue.ValueName
+			changes.Save = append(changes.Save, &restored)
+		} else if !ok {
+			diff.Added = append(diff.Added, key)
+			changes.Save = append(changes.Save, &dao.DbpFieldEnumValue{Field: enumValue.Field, EnumValue: enumValue.EnumValue, ValueName: enumValue.ValueName})
+		} else if old.ValueName != enumValue.ValueName {
+			diff.Changed = append(diff.Changed, key)
+			old.ValueName = enumValue.ValueName
+			changes.Save = append(changes.Save, &old)
+		}
+	}
+	for _, enumValue := range current {
+		key := enumValueKey(enumValue.Field, enumValue.EnumValue)
+		if !targetKeys[key] {
+			enumValue := enumValue
+			diff.Removed = append(diff.Removed, key)
+			changes.Delete = append(changes.Delete, &enumValue)
+		}
+	}
+	diff.sort()
+}
+
+func diffProfileFields(current []dao.DbpProfileField, target []dao.DbpProfileField, diff *TableDiff, changes *dao.MetadataChanges) {
+	currentMap := make(map[string]dao.DbpProfileField, len(current))
+	for _, field := range current {
+		currentMap[field.Field] = field
+	}
+	targetKeys := make(map[string]bool, len(target))
+	for _, field := range target {
+		targetKeys[field.Field] = true
+		old, ok := currentMap[field.Field]
+		if !ok {
+			diff.Added = append(diff.Added, field.Field)
+			changes.Save = append(changes.Save, &dao.DbpProfileField{Field: field.Field, JsonPath: field.JsonPath, Type: field.Type,
+				Length: field.Length, Name: field.Name, Nullable: field.Nullable,
+				ElementType: field.ElementType, MaxElements: field.MaxElements, Parent: field.Parent})
+		} else if !sameField(old.ToDbpField(), field.ToDbpField()) {
+			diff.Changed = append(diff.Changed, field.Field)
+			old.JsonPath, old.Type, old.Length, old.Name, old.Nullable = field.JsonPath, field.Type, field.Length, field.Name, field.Nullable
+			old.ElementType, old.MaxElements, old.Parent = field.ElementType, field.MaxElements, field.Parent
+			changes.Save = append(changes.Save, &old)
+		}
+	}
+	for _, field := range current {
+		if !targetKeys[field.Field] {
+			field := field
+			diff.Removed = append(diff.Removed, field.Field)
+			changes.Delete = append(changes.Delete, &field)
+		}
+	}
+	diff.sort()
+}
+
+func diffItemFields(current []dao.DbpItemField, target []dao.DbpItemField, diff *TableDiff, changes *dao.MetadataChanges) {
+	currentMap := make(map[string]dao.DbpItemField, len(current))
+	for _, field := range current {
+		currentMap[itemFieldKey(field.ItemType, field.Field)] = field
+	}
+	targetKeys := make(map[string]bool, len(target))
+	for _, field := range target {
+		key := itemFieldKey(field.ItemType, field.Field)
+		targetKeys[key] = true
+		old, ok := currentMap[key]
+		if !ok {
+			diff.Added = append(diff.Added, key)
+			changes.Save = append(changes.Save, &dao.DbpItemField{ItemType: field.ItemType, Field: field.Field, JsonPath: field.JsonPath,
+				Type: field.Type, Length: field.Length, Name: field.Name, Nullable: field.Nullable,
+				ElementType: field.ElementType, MaxElements: field.MaxElements, Parent: field.Parent})
+		} else if !sameField(old.ToDbpField(), field.ToDbpField()) {
+			diff.Changed = append(diff.Changed, key)
+			old.JsonPath, old.Type, old.Length, old.Name, old.Nullable = field.JsonPath, field.Type, field.Length, field.Name, field.Nullable
+			old.ElementType, old.MaxElements, old.Parent = field.ElementType, field.MaxElements, field.Parent
+			changes.Save = append(changes.Save, &old)
+		}
+	}
+	for _, field := range current {
+		key := itemFieldKey(field.ItemType, field.Field)
+		if !targetKeys[key] {
+			field := field
+			diff.Removed = append(diff.Removed, key)
+			changes.Delete = append(changes.Delete, &field)
+		}
+	}
+	diff.sort()
+}
+
+// sameField 比较字段定义内容，不比较 id 和时间
+func sameField(a dao.DbpField, b dao.DbpField) bool {
+	return a.JsonPath == b.JsonPath && a.Type == b.Type && a.Length == b.Length && a.Name == b.Name && a.Nullable == b.Nullable &&
+		a.ElementType == b.ElementType && a.MaxElements == b.MaxElements && a.Parent == b.Parent &&
+		constraint.Normalize(a.Constraints) == constraint.Normalize(b.Constraints) && a.Policy == b.Policy &&
+		a.Coerce == b.Coerce
+}
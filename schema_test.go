@@ -0,0 +1,74 @@
+package main
+
+import (
+	"liangck.xyz/data-service/sensors-log-acceptor/cache"
+	"liangck.xyz/data-service/sensors-log-acceptor/dao"
+	"reflect"
+	"testing"
+)
+
+func TestDiffSchema(t *testing.T) {
+	current := &cache.Metadata{
+		Events: []dao.DbpEvent{{ID: 1, Event: "page_view", Description: "浏览页面"}, {ID: 2, Event: "old_event"}},
+		Fields: []dao.DbpField{{ID: 1, Field: "page_id", JsonPath: "properties.page_id", Type: TypeString, Length: 64}},
+	}
+	target := &cache.Metadata{
+		Events: []dao.DbpEvent{{Event: "page_view", Description: "页面浏览"}, {Event: "click"}},
+		Fields: []dao.DbpField{{Field: "page_id", JsonPath: "properties.page_id", Type: TypeString, Length: 64}},
+	}
+
+	diff, changes := diffSchema(current, target, nil)
+	expected := TableDiff{Added: []string{"click"}, Removed: []string{"old_event"}, Changed: []string{"page_view"}}
+	if !reflect.DeepEqual(diff.Events, expected) {
+		t.Fatalf("events diff should be %v, got %v", expected, diff.Events)
+	}
+	if !diff.Fields.empty() {
+		t.Fatalf("fields should not change, got %v", diff.Fields)
+	}
+	if len(changes.Save) != 2 || len(changes.Delete) != 1 {
+		t.Fatalf("should save 2 and delete 1 records, got %d, %d", len(changes.Save), len(changes.Delete))
+	}
+	// 修改的记录保留 id
+	for _, record := range changes.Save {
+		if event := record.(*dao.DbpEvent); event.Event == "page_view" && event.ID != 1 {
+			t.Fatalf("changed event should keep id 1, got %d", event.ID)
+		}
+	}
+
+	if diff, _ := diffSchema(current, current, nil); !diff.Empty() {
+		t.Fatalf("same metadata should have no diff, got %s", diff)
+	}
+}
+
+func TestDiffSchemaRestoreEnumValue(t *testing.T) {
+	deleted := dao.DbpFieldEnumValue{ID: 3, Field: "page_type", EnumValue: "home", ValueName: "首页"}
+	deleted.DeletedAt.Valid = true
+	target := &cache.Metadata{EnumValues: []dao.DbpFieldEnumValue{{Field: "page_type", EnumValue: "home", ValueName: "主页"}}}
+
+	diff, changes := diffSchema(&cache.Metadata{}, target, []dao.DbpFieldEnumValue{deleted})
+	if len(diff.EnumValues.Added) != 1 || len(changes.Save) != 1 {
+		t.Fatalf("deleted enum value should be restored, got %v", diff.EnumValues)
+	}
+	// 恢复原记录（保留 id、清空 deleted_at），不新增记录
+	restored := changes.Save[0].(*dao.DbpFieldEnumValue)
+	if restored.ID != 3 || restored.DeletedAt.Valid || restored.ValueName != "主页" {
+		t.Fatalf("unexpected restored enum value %v", restored)
+	}
+}
+
+func TestValidBundle(t *testing.T) {
+	bundle := &cache.MetadataFile{
+		Events:      []cache.FileEvent{{Event: "page_view"}},
+		Fields:      []cache.FileField{{Field: "page_type", JsonPath: "properties.page_type", Type: TypeEnum, Length: 32}},
+		EventFields: []cache.FileEventField{{Event: "page_view", Field: "page_type"}},
+		EnumValues:  []cache.FileEnumValue{{Field: "page_type", EnumValue: "home"}},
+	}
+	if err := validBundle(bundle); err != nil {
+		t.Fatalf("bundle should be valid, got %v", err)
+	}
+
+	bundle.EventFields = append(bundle.EventFields, cache.FileEventField{Event: "click", Field: "page_type"})
+	if err := validBundle(bundle); err == nil {
+		t.Fatal("event field of undefined event should be invalid")
+	}
+}
@@ -0,0 +1,302 @@
+package spool
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// -------------------- Spool. 本地落盘队列
+// kafka 不可用时，发送失败的消息追加写入本地分段文件，kafka 恢复后由后台任务按写入顺序重新发送。
+//
+// 文件格式：
+//	目录下为若干分段文件（{segmentId}.seg），segmentId 递增，数值越小越早写入。
+//	每条记录为 [4字节长度][4字节crc32][json(Record)]，进程异常退出导致的不完整记录在回放时丢弃。
+//
+// 回放时只读取已封存（不再写入）的分段文件，整个分段发送成功后删除该文件，
+// 分段中途发送失败时整段保留待下次回放，所以回放是 at-least-once 的，下游需要容忍少量重复消息。
+//------------------------
+
+const segmentSuffix = ".seg"
+const recordHeaderSize = 8
+
+// ErrFull 落盘数据达到上限，消息被丢弃
+var ErrFull = errors.New("spool is full")
+
+// Record 落盘的消息
+type Record struct {
+	Topic string
+	Key   []byte
+	Value []byte
+}
+
+// Conf spool configuration
+type Conf struct {
+	Dir             string // 落盘目录
+	SegmentMaxBytes int64  // 单个分段文件最大字节数，超过后切换到新的分段
+	MaxBytes        int64  // 落盘数据最大字节数，超过后丢弃新消息
+}
+
+// Stats 落盘积压情况
+type Stats struct {
+	Segments int   // 分段文件数
+	Bytes    int64 // 积压字节数
+	Records  int64 // 积压消息数
+	Dropped  int64 // 超过上限被丢弃的消息数
+}
+
+type Spool struct {
+	conf *Conf
+
+	mu         sync.Mutex
+	writeFile  *os.File
+	writeID    int64
+	writeBytes int64
+	segments   []int64 // 已封存的分段id，升序
+	bytes      int64
+	records    int64
+
+	replayMu sync.Mutex
+	dropped  int64
+}
+
+// Open 打开落盘目录，加载已存在的分段文件
+func Open(conf *Conf) (*Spool, error) {
+	if err := os.MkdirAll(conf.Dir, 0755); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(conf.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Spool{conf: conf}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		id, err := strconv.ParseInt(strings.TrimSuffix(name, segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		records, err := countRecords(s.segmentPath(id))
+		if err != nil {
+			return nil, err
+		}
+		s.segments = append(s.segments, id)
+		s.bytes += info.Size()
+		s.records += records
+		if id >= s.writeID {
+			s.writeID = id + 1
+		}
+	}
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i] < s.segments[j] })
+
+	return s, nil
+}
+
+// Append 追加消息到当前分段，写入后 fsync 保证落盘
+// 超过 MaxBytes 的消息丢弃并计数，其余（更小的）消息继续写入，有消息被丢弃时返回 ErrFull
+func (s *Spool) Append(records ...Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	full := false
+	for _, record := range records {
+		encoded, err := encodeRecord(record)
+		if err != nil {
+			return err
+		}
+		size := int64(len(encoded))
+		if s.conf.MaxBytes > 0 && s.bytes+size > s.conf.MaxBytes {
+			atomic.AddInt64(&s.dropped, 1)
+			full = true
+			continue
+		}
+		if s.writeFile != nil && s.conf.SegmentMaxBytes > 0 && s.writeBytes+size > s.conf.SegmentMaxBytes {
+			if err := s.sealLocked(); err != nil {
+				return err
+			}
+		}
+		if s.writeFile == nil {
+			file, err := os.OpenFile(s.segmentPath(s.writeID), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+			if err != nil {
+				return err
+			}
+			s.writeFile = file
+		}
+		if _, err := s.writeFile.Write(encoded); err != nil {
+			return err
+		}
+		s.writeBytes += size
+		s.bytes += size
+		s.records++
+	}
+
+	if s.writeFile != nil {
+		if err := s.writeFile.Sync(); err != nil {
+			return err
+		}
+	}
+	if full {
+		return ErrFull
+	}
+	return nil
+}
+
+// Replay 按写入顺序回放积压的消息，send 返回错误时停止回放，未发送成功的分段保留
+func (s *Spool) Replay(batchSize int, send func(records []Record) error) error {
+	s.replayMu.Lock()
+	defer s.replayMu.Unlock()
+
+	// 封存当前写入的分段，使其可以被回放
+	s.mu.Lock()
+	if s.writeFile != nil {
+		if err := s.sealLocked(); err != nil {
+			s.mu.Unlock()
+			return err
+		}
+	}
+	segments := append([]int64(nil), s.segments...)
+	s.mu.Unlock()
+
+	for _, id := range segments {
+		path := s.segmentPath(id)
+		records, err := readRecords(path)
+		if err != nil {
+			return err
+		}
+		for start := 0; start < len(records); start += batchSize {
+			end := start + batchSize
+			if end > len(records) {
+				end = len(records)
+			}
+			if err := send(records[start:end]); err != nil {
+				return err
+			}
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		s.mu.Lock()
+		s.segments = s.segments[1:]
+		s.bytes -= info.Size()
+		s.records -= int64(len(records))
+		s.mu.Unlock()
+	}
+
+	return nil
+}
+
+// Stats 获取积压情况
+func (s *Spool) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	segments := len(s.segments)
+	if s.writeFile != nil {
+		segments++
+	}
+	return Stats{Segments: segments, Bytes: s.bytes, Records: s.records, Dropped: atomic.LoadInt64(&s.dropped)}
+}
+
+// Close 关闭当前写入的分段
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.writeFile == nil {
+		return nil
+	}
+	return s.sealLocked()
+}
+
+// 封存当前写入的分段，调用方需持有 mu
+func (s *Spool) sealLocked() error {
+	err := s.writeFile.Close()
+	s.writeFile = nil
+	s.segments = append(s.segments, s.writeID)
+	s.writeID++
+	s.writeBytes = 0
+	return err
+}
+
+func (s *Spool) segmentPath(id int64) string {
+	return filepath.Join(s.conf.Dir, fmt.Sprintf("%020d%s", id, segmentSuffix))
+}
+
+func encodeRecord(record Record) ([]byte, error) {
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	encoded := make([]byte, recordHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(encoded[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(encoded[4:8], crc32.ChecksumIEEE(payload))
+	copy(encoded[recordHeaderSize:], payload)
+	return encoded, nil
+}
+
+// readRecords 读取分段中的所有完整记录，遇到不完整或损坏的记录时停止
+func readRecords(path string) ([]Record, error) {
+	var records []Record
+	err := scanRecords(path, func(payload []byte) {
+		var record Record
+		if json.Unmarshal(payload, &record) == nil {
+			records = append(records, record)
+		}
+	})
+	return records, err
+}
+
+func countRecords(path string) (int64, error) {
+	var count int64
+	err := scanRecords(path, func(payload []byte) {
+		count++
+	})
+	return count, err
+}
+
+func scanRecords(path string, fn func(payload []byte)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	header := make([]byte, recordHeaderSize)
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			// io.EOF 为正常结束，io.ErrUnexpectedEOF 为不完整的记录
+			return nil
+		}
+		payload := make([]byte, binary.BigEndian.Uint32(header[0:4]))
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			return nil
+		}
+		if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+			return nil
+		}
+		fn(payload)
+	}
+}
@@ -0,0 +1,111 @@
+package spool
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestAppendAndReplay(t *testing.T) {
+	conf := &Conf{Dir: t.TempDir(), SegmentMaxBytes: 200}
+	s, err := Open(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := s.Append(Record{Topic: "user_event_log", Key: []byte("u1"), Value: []byte(strconv.Itoa(i))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stats := s.Stats(); stats.Records != 10 || stats.Segments < 2 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	// 发送失败时积压保留
+	sendErr := errors.New("kafka unavailable")
+	if err := s.Replay(3, func(records []Record) error { return sendErr }); err != sendErr {
+		t.Fatalf("replay should return send error, got %v", err)
+	}
+	if stats := s.Stats(); stats.Records != 10 {
+		t.Fatalf("records should be kept after failed replay, got %+v", stats)
+	}
+
+	var replayed []string
+	err = s.Replay(3, func(records []Record) error {
+		for _, record := range records {
+			replayed = append(replayed, string(record.Value))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replayed) != 10 || replayed[0] != "0" || replayed[9] != "9" {
+		t.Fatalf("records should be replayed in order, got %v", replayed)
+	}
+	if stats := s.Stats(); stats.Records != 0 || stats.Bytes != 0 || stats.Segments != 0 {
+		t.Fatalf("spool should be drained, got %+v", stats)
+	}
+}
+
+func TestReopenAndTruncatedRecord(t *testing.T) {
+	conf := &Conf{Dir: t.TempDir()}
+	s, _ := Open(conf)
+	_ = s.Append(Record{Topic: "t", Value: []byte("a")}, Record{Topic: "t", Value: []byte("b")})
+	_ = s.Close()
+
+	// 模拟写入过程中进程退出，最后一条记录不完整
+	file, _ := os.OpenFile(s.segmentPath(0), os.O_WRONLY|os.O_APPEND, 0644)
+	_, _ = file.Write([]byte{0, 0, 0, 100, 1, 2})
+	_ = file.Close()
+
+	reopened, err := Open(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats := reopened.Stats(); stats.Records != 2 {
+		t.Fatalf("reopened spool should have 2 records, got %+v", stats)
+	}
+	_ = reopened.Append(Record{Topic: "t", Value: []byte("c")})
+
+	var replayed []string
+	_ = reopened.Replay(10, func(records []Record) error {
+		for _, record := range records {
+			replayed = append(replayed, string(record.Value))
+		}
+		return nil
+	})
+	if len(replayed) != 3 || replayed[2] != "c" {
+		t.Fatalf("unexpected replayed records %v", replayed)
+	}
+}
+
+func TestMaxBytes(t *testing.T) {
+	s, _ := Open(&Conf{Dir: t.TempDir(), MaxBytes: 100})
+	var err error
+	for i := 0; i < 10 && err == nil; i++ {
+		err = s.Append(Record{Topic: "user_event_log", Value: []byte("value")})
+	}
+	if err != ErrFull {
+		t.Fatalf("append should fail with ErrFull, got %v", err)
+	}
+	if stats := s.Stats(); stats.Dropped != 1 || stats.Bytes > 100 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	// 批量写入时超出上限的消息逐条计数，之后能写下的更小的消息继续写入
+	large := Record{Topic: "user_event_log", Value: make([]byte, 60)}
+	small := Record{Topic: "t", Value: []byte("v")}
+	largeEncoded, _ := encodeRecord(large)
+	smallEncoded, _ := encodeRecord(small)
+	maxBytes := int64(len(largeEncoded) + len(smallEncoded))
+	s, _ = Open(&Conf{Dir: t.TempDir(), MaxBytes: maxBytes})
+	err = s.Append(large, large, large, small)
+	if err != ErrFull {
+		t.Fatalf("append should fail with ErrFull, got %v", err)
+	}
+	if stats := s.Stats(); stats.Dropped != 2 || stats.Records != 2 || stats.Bytes != maxBytes {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}